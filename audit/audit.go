@@ -0,0 +1,76 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package audit
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+/**
+ * Opens the audit log at the given path, creating it if
+ * it doesn't exist.
+ *
+ * @param path string Path to the log file
+ * @return *Logger Audit logger
+ * @return error Error object
+ */
+func Open(path string) (*Logger, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Logger{file: file}, nil
+}
+
+/**
+ * Appends an entry to the audit log. Does nothing if the
+ * audit log is disabled, so callers can use a nil Logger.
+ *
+ * @param entry Entry Entry to append
+ */
+func (l *Logger) Record(entry Entry) {
+	if l == nil {
+		return
+	}
+
+	entry.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	line, _ := json.Marshal(entry)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		log.Error("Could not write audit log", "Error", err)
+	}
+}
+
+/**
+ * Closes the audit log.
+ */
+func (l *Logger) Close() {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.file.Close()
+}
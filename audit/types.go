@@ -0,0 +1,66 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package audit
+
+import (
+	"os"
+	"sync"
+)
+
+/**
+ * Struct for the audit log, a file with one JSON object
+ * per submitted execution.
+ *
+ * @field file *os.File Log file, opened for appending
+ * @field mu sync.Mutex Mutex for writes
+ */
+type Logger struct {
+	file *os.File
+	mu   sync.Mutex
+}
+
+/**
+ * Struct for an audit log entry. Code and stdin are only
+ * recorded as hashes, so that abusive submissions can be
+ * matched up without keeping what was submitted.
+ *
+ * @field Time string Time of the submission, RFC 3339
+ * @field RequestID string ID of the request
+ * @field KeyID string ID of the key used
+ * @field ClientIP string Address of the client
+ * @field Route string Route the execution was submitted to
+ * @field Language string Entry of the language
+ * @field CodeSHA256 string Hash over the paths and contents
+ *   of all files
+ * @field CodeBytes int Total size of the files
+ * @field Files int Number of files
+ * @field StdinSHA256 string Hash of stdin, empty if none
+ * @field TestCases int Number of test cases
+ */
+type Entry struct {
+	Time        string `json:"time"`
+	RequestID   string `json:"request_id"`
+	KeyID       string `json:"key_id"`
+	ClientIP    string `json:"client_ip"`
+	Route       string `json:"route"`
+	Language    string `json:"language"`
+	CodeSHA256  string `json:"code_sha256"`
+	CodeBytes   int    `json:"code_bytes"`
+	Files       int    `json:"files"`
+	StdinSHA256 string `json:"stdin_sha256,omitempty"`
+	TestCases   int    `json:"test_cases"`
+}
@@ -0,0 +1,376 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package bwrap
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"whipcode/sandbox"
+
+	"github.com/charmbracelet/log"
+)
+
+/**
+ * Magic number of tmpfs in statfs.
+ */
+const tmpfsMagic = 0x01021994
+
+/**
+ * Script that runs bwrap inside the scope of a phase. The
+ * scope's cgroup is gone as soon as the phase is over, so
+ * its OOM kill count is written to the file given as the
+ * first argument before the script exits with the status
+ * of bwrap.
+ */
+const oomScript = `out=$1 && shift
+"$@"
+status=$?
+cgroup=$(sed -n 's/^0:://p' /proc/self/cgroup)
+sed -n 's/^oom_kill //p' "/sys/fs/cgroup$cgroup/memory.events" >"$out" 2>/dev/null
+exit $status`
+
+/**
+ * Creates a new bubblewrap runtime. Sandboxes are much
+ * cheaper to start than containers. Every phase is run in
+ * a transient systemd scope for its memory, cpu and process
+ * limits, with a size limited tmpfs on /tmp. The output of
+ * the compile phase is kept on a tmpfs so that it counts
+ * against the memory limit. Fails if any of them is not
+ * available, as the limits could not be enforced.
+ *
+ * @param bwrapPath string Path to bwrap executable
+ * @param systemdRunPath string Path to systemd-run
+ * @param systemctlPath string Path to systemctl
+ * @param rootfsDir string Directory with the root
+ *   filesystems of the languages
+ * @param tmpDir string Directory on a tmpfs for the
+ *   compile output of the sandboxes
+ * @return *Runtime New Runtime instance
+ * @return error Error object
+ */
+func NewRuntime(bwrapPath, systemdRunPath, systemctlPath, rootfsDir, tmpDir string) (*Runtime, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(tmpDir, &stat); err != nil {
+		return nil, fmt.Errorf("tmp dir %s: %w", tmpDir, err)
+	}
+	if stat.Type != tmpfsMagic {
+		return nil, fmt.Errorf("tmp dir %s is not on a tmpfs", tmpDir)
+	}
+
+	out, err := exec.Command(bwrapPath, "--version").Output()
+	if err != nil {
+		return nil, fmt.Errorf("could not get the bwrap version: %w", err)
+	}
+	if !sizeSupported(strings.TrimSpace(string(out))) {
+		return nil, fmt.Errorf("%s does not support size limited tmpfs mounts, 0.10.0 or newer is needed", strings.TrimSpace(string(out)))
+	}
+
+	rt := &Runtime{
+		bwrapPath:      bwrapPath,
+		systemdRunPath: systemdRunPath,
+		systemctlPath:  systemctlPath,
+		userScope:      os.Geteuid() != 0,
+		rootfsDir:      rootfsDir,
+		tmpDir:         tmpDir,
+		oomPolicy:      true,
+	}
+
+	probe := sandbox.Limits{Memory: sandbox.MinMemory, CPUs: 1, Pids: 1}
+	unit := fmt.Sprintf("whipcode-probe%d", os.Getpid())
+	if err := exec.Command(systemdRunPath, rt.scopeArgs(unit, probe, "true")...).Run(); err != nil {
+		rt.oomPolicy = false
+		if out, err := exec.Command(systemdRunPath, rt.scopeArgs(unit, probe, "true")...).CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("could not create a systemd scope with resource limits: %w: %s", err, strings.TrimSpace(string(out)))
+		}
+	}
+
+	return rt, nil
+}
+
+/**
+ * Checks if the output of bwrap --version is of a release
+ * that supports --size, which was added in 0.10.0.
+ *
+ * @param version string Output of bwrap --version
+ * @return bool True if --size is supported
+ */
+func sizeSupported(version string) bool {
+	fields := strings.Fields(version)
+	if len(fields) != 2 || fields[0] != "bubblewrap" {
+		return false
+	}
+
+	parts := strings.Split(fields[1], ".")
+	major, err := strconv.Atoi(parts[0])
+	if err != nil || len(parts) < 2 {
+		return false
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return false
+	}
+
+	return major > 0 || minor >= 10
+}
+
+/**
+ * Builds the systemd-run arguments that run a command in
+ * a transient scope with the given name and limits. The
+ * process limit leaves room for the bwrap processes and
+ * the script around them. Where systemd supports it, the
+ * scope is kept running when the OOM killer hits one of
+ * its processes, so that the kill can be recorded.
+ *
+ * @param unit string Name of the scope, without suffix
+ * @param limits sandbox.Limits Resource limits
+ * @param command ...string Command to run
+ * @return []string Arguments for systemd-run
+ */
+func (rt *Runtime) scopeArgs(unit string, limits sandbox.Limits, command ...string) []string {
+	args := []string{"--scope", "--quiet", "--collect", "--unit", unit}
+	if rt.userScope {
+		args = append(args, "--user")
+	}
+	args = append(args,
+		"--property", fmt.Sprintf("MemoryMax=%d", limits.Memory),
+		"--property", "MemorySwapMax=0",
+		"--property", fmt.Sprintf("CPUQuota=%d%%", int(math.Ceil(limits.CPUs*100))),
+		"--property", fmt.Sprintf("TasksMax=%d", limits.Pids+3),
+	)
+	if rt.oomPolicy {
+		args = append(args, "--property", "OOMPolicy=continue")
+	}
+	args = append(args, "--")
+	return append(args, command...)
+}
+
+/**
+ * Prepares a sandbox for the given execution. The files
+ * are dumped into a temp directory, which is bound
+ * read-only as the working directory of every phase.
+ *
+ * @param opt sandbox.ExecutionOptions Execution options
+ * @param lifetime int Maximum lifetime in seconds, unused
+ *   as every phase has its own timeout
+ * @return sandbox.Box Prepared sandbox
+ * @return error Error object
+ */
+func (rt *Runtime) Start(opt sandbox.ExecutionOptions, lifetime int) (sandbox.Box, error) {
+	rootfs, err := filepath.Abs(filepath.Join(rt.rootfsDir, opt.Entry))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(rootfs); err != nil {
+		return nil, fmt.Errorf("no root filesystem for %s: %w", opt.Entry, err)
+	}
+
+	boxID := sandbox.BoxID(opt.RequestID)
+	dir, err := filepath.Abs(filepath.Join(".", "run", "run"+boxID))
+	if err != nil {
+		return nil, err
+	}
+
+	b := &box{
+		rt:        rt,
+		bwrapPath: rt.bwrapPath,
+		boxID:     boxID,
+		dir:       dir,
+		tmp:       filepath.Join(rt.tmpDir, "whipcode-run"+boxID),
+		rootfs:    rootfs,
+		opt:       opt,
+	}
+	rt.boxes.Store(boxID, b)
+
+	if err := sandbox.WriteProject(dir, nil); err != nil {
+		b.Remove()
+		return nil, err
+	}
+	if err := sandbox.WriteProject(filepath.Join(dir, "project"), opt.Files); err != nil {
+		b.Remove()
+		return nil, err
+	}
+	if err := os.Mkdir(b.tmp, 0700); err != nil {
+		b.Remove()
+		return nil, err
+	}
+
+	return b, nil
+}
+
+/**
+ * Returns the modification time of the language's root
+ * filesystem, which changes whenever it is exported
+ * again.
+ *
+ * @param entry string Entry point, names the root filesystem
+ * @return string Modification time, empty if unknown
+ */
+func (rt *Runtime) ImageID(entry string) string {
+	info, err := os.Stat(filepath.Join(rt.rootfsDir, entry))
+	if err != nil {
+		return ""
+	}
+	return strconv.FormatInt(info.ModTime().UnixNano(), 10)
+}
+
+/**
+ * Kills the processes of every sandbox that is still in
+ * use. Their directories are left to the cleanup of the
+ * temp directory.
+ */
+func (rt *Runtime) Close() {
+	rt.boxes.Range(func(_, value any) bool {
+		value.(*box).KillAll()
+		return true
+	})
+}
+
+/**
+ * Builds the bwrap command that runs a shell script in a
+ * fresh set of namespaces, with the language's root
+ * filesystem, the project and a new tmpfs of the sandbox's
+ * size on /tmp. bwrap is started in a scope of its own
+ * with the limits of the sandbox, named after the sandbox
+ * and the phase.
+ *
+ * The first phase of a compiled language is the compile
+ * phase. What it leaves in /tmp is copied to the
+ * sandbox's tmp directory, bound to /var/tmp, and copied
+ * back into /tmp by every later phase.
+ *
+ * @param ctx context.Context Context of the phase
+ * @param interactive bool Whether stdin is attached, unused
+ *   as bwrap always passes stdin through
+ * @param script string Shell script to run
+ * @return *exec.Cmd Command object
+ */
+func (b *box) Command(ctx context.Context, interactive bool, script string) *exec.Cmd {
+	entryDir, _ := filepath.Abs("entry")
+
+	b.mu.Lock()
+	phase := len(b.scopes)
+	unit := fmt.Sprintf("whipcode-run%s-%d", b.boxID, phase)
+	b.scopes = append(b.scopes, unit+".scope")
+	b.mu.Unlock()
+
+	args := []string{
+		"--ro-bind", b.rootfs, "/",
+		"--ro-bind", filepath.Join(b.dir, "project"), "/project",
+		"--size", strconv.FormatInt(b.opt.Limits.Tmpfs, 10), "--tmpfs", "/tmp",
+		"--ro-bind", filepath.Join(entryDir, b.opt.Run+".sh"), "/entry.sh",
+		"--proc", "/proc",
+		"--dev", "/dev",
+		"--unshare-all",
+		"--die-with-parent",
+		"--new-session",
+		"--hostname", "box" + b.boxID,
+		"--chdir", "/project",
+		"--clearenv",
+		"--setenv", "PATH", "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+		"--setenv", "HOME", "/tmp",
+	}
+	if b.opt.Compile != "" {
+		args = append(args, "--ro-bind", filepath.Join(entryDir, b.opt.Compile+".sh"), "/compile.sh")
+		if phase == 0 {
+			args = append(args, "--bind", b.tmp, "/var/tmp")
+			script = fmt.Sprintf("{ %s\n}; status=$? && cp -a /tmp/. /var/tmp/ && exit $status", script)
+		} else {
+			args = append(args, "--ro-bind", b.tmp, "/var/tmp")
+			script = "cp -a /var/tmp/. /tmp/ 2>/dev/null; " + script
+		}
+	}
+	for k, v := range b.opt.Env {
+		args = append(args, "--setenv", k, v)
+	}
+	args = append(args, "sh", "-c", script)
+
+	command := []string{"sh", "-c", oomScript, "sh", filepath.Join(b.dir, fmt.Sprintf("oom%d", phase)), b.bwrapPath}
+	scope := b.rt.scopeArgs(unit, b.opt.Limits, append(command, args...)...)
+	return exec.CommandContext(ctx, b.rt.systemdRunPath, scope...)
+}
+
+/**
+ * Adds up the OOM kills recorded by the phases of the
+ * sandbox that have finished. Phases that were killed
+ * before they could record theirs are not counted.
+ *
+ * @return int Number of OOM kills
+ */
+func (b *box) OOMKills() int {
+	files, _ := filepath.Glob(filepath.Join(b.dir, "oom*"))
+
+	total := 0
+	for _, file := range files {
+		count, err := os.ReadFile(file)
+		if err != nil {
+			log.Debug("Could not read OOM kills", "ID", b.boxID, "Error", err)
+			continue
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(string(count)))
+		total += n
+	}
+
+	return total
+}
+
+/**
+ * Kills everything in the scopes of the phases started in
+ * the sandbox. The scopes of finished phases are already
+ * gone, and failing to kill them is expected.
+ */
+func (b *box) KillAll() {
+	b.mu.Lock()
+	scopes := slices.Clone(b.scopes)
+	b.mu.Unlock()
+
+	if len(scopes) == 0 {
+		return
+	}
+
+	args := []string{"kill", "--signal", "SIGKILL"}
+	if b.rt.userScope {
+		args = append(args, "--user")
+	}
+	args = append(args, scopes...)
+
+	if out, err := exec.Command(b.rt.systemctlPath, args...).CombinedOutput(); err != nil {
+		log.Debug("Could not kill sandbox", "ID", b.boxID, "Error", err, "Output", strings.TrimSpace(string(out)))
+	}
+}
+
+/**
+ * Kills what is left in the sandbox and removes its temp
+ * directories.
+ */
+func (b *box) Remove() {
+	b.rt.boxes.Delete(b.boxID)
+	b.KillAll()
+	for _, dir := range []string{b.dir, b.tmp} {
+		if err := os.RemoveAll(dir); err != nil {
+			log.Error("Could not remove sandbox", "ID", b.boxID, "Error", err)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package bwrap
+
+import "testing"
+
+func TestSizeSupported(t *testing.T) {
+	cases := map[string]bool{
+		"bubblewrap 0.10.0": true,
+		"bubblewrap 0.11.1": true,
+		"bubblewrap 1.0":    true,
+		"bubblewrap 0.9.0":  false,
+		"bubblewrap 0.8":    false,
+		"bubblewrap":        false,
+		"bwrap 0.10.0":      false,
+		"":                  false,
+	}
+
+	for version, want := range cases {
+		if got := sizeSupported(version); got != want {
+			t.Errorf("sizeSupported(%q) = %v, want %v", version, got, want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package bwrap
+
+import (
+	"sync"
+
+	"whipcode/sandbox"
+)
+
+/**
+ * Struct for the bubblewrap runtime.
+ *
+ * @field bwrapPath string Path to the bwrap executable
+ * @field systemdRunPath string Path to the systemd-run
+ *   executable
+ * @field systemctlPath string Path to the systemctl
+ *   executable
+ * @field userScope bool Whether scopes are created in the
+ *   user's service manager
+ * @field rootfsDir string Directory with a root filesystem
+ *   for each language, named after its entry
+ * @field tmpDir string Directory on a tmpfs for the compile
+ *   output of the sandboxes
+ * @field oomPolicy bool Whether systemd supports OOMPolicy
+ *   on scopes
+ * @field boxes sync.Map Sandboxes that are in use, keyed
+ *   by ID
+ */
+type Runtime struct {
+	bwrapPath      string
+	systemdRunPath string
+	systemctlPath  string
+	userScope      bool
+	rootfsDir      string
+	tmpDir         string
+	oomPolicy      bool
+	boxes          sync.Map
+}
+
+/**
+ * Struct for a prepared bubblewrap sandbox. Every phase
+ * runs in a new bwrap process with its own /tmp, sharing
+ * the sandbox's project and compile output.
+ *
+ * @field rt *Runtime Runtime the sandbox belongs to
+ * @field bwrapPath string Path to the bwrap executable
+ * @field boxID string ID of the sandbox, based on the
+ *   request ID
+ * @field dir string Temp directory of the sandbox
+ * @field tmp string Directory the compile output is kept
+ *   in, on a tmpfs
+ * @field rootfs string Root filesystem of the language
+ * @field opt sandbox.ExecutionOptions Execution options
+ * @field scopes []string Scope units of the phases started
+ *   in the sandbox
+ * @field mu sync.Mutex Mutex for scopes
+ */
+type box struct {
+	rt        *Runtime
+	bwrapPath string
+	boxID     string
+	dir       string
+	tmp       string
+	rootfs    string
+	opt       sandbox.ExecutionOptions
+	scopes    []string
+	mu        sync.Mutex
+}
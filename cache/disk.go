@@ -0,0 +1,259 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package cache
+
+import (
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+/**
+ * Creates a new store in the given directory, picking up
+ * the results that are already in it.
+ *
+ * @param dir string Directory to keep the files in
+ * @param maxBytes int64 Max total size of the files
+ * @param ttl int Seconds results are kept
+ * @return *DiskStore New DiskStore instance
+ * @return error Error object
+ */
+func NewDiskStore(dir string, maxBytes int64, ttl int) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	s := &DiskStore{
+		dir:      dir,
+		ttl:      time.Duration(ttl) * time.Second,
+		maxBytes: maxBytes,
+	}
+
+	files, err := s.files()
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		s.size.Add(file.size)
+	}
+
+	return s, nil
+}
+
+/**
+ * Returns the path of the file for the given key.
+ *
+ * @param key string Cache key, a hex encoded hash
+ * @return string File path
+ */
+func (s *DiskStore) path(key string) string {
+	return filepath.Join(s.dir, key[:2], key+".json")
+}
+
+/**
+ * Looks up a result that hasn't expired yet. Hits bump
+ * the modification time of the file, which evictions go
+ * by.
+ *
+ * @param key string Cache key
+ * @return map[string]interface{} Cached response body
+ * @return bool Whether the key was found
+ */
+func (s *DiskStore) Get(key string) (map[string]interface{}, bool) {
+	path := s.path(key)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		s.misses.Add(1)
+		return nil, false
+	}
+
+	var entry diskEntry
+	if err := json.Unmarshal(data, &entry); err != nil || time.Now().Unix() >= entry.Expires {
+		s.remove(path, int64(len(data)))
+		s.misses.Add(1)
+		return nil, false
+	}
+
+	now := time.Now()
+	os.Chtimes(path, now, now)
+
+	s.hits.Add(1)
+	return entry.Value, true
+}
+
+/**
+ * Writes a result to its file. The file is written under
+ * a temporary name first and renamed, so readers never
+ * see it half written. Least recently used files are
+ * evicted in the background if the store grows past its
+ * size limit.
+ *
+ * @param key string Cache key
+ * @param value map[string]interface{} Response body
+ */
+func (s *DiskStore) Set(key string, value map[string]interface{}) {
+	data, err := json.Marshal(diskEntry{
+		Expires: time.Now().Add(s.ttl).Unix(),
+		Value:   value,
+	})
+	if err != nil {
+		log.Error("Could not encode cache entry", "Error", err)
+		return
+	}
+
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		log.Error("Could not write cache entry", "Error", err)
+		return
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		log.Error("Could not write cache entry", "Error", err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		log.Error("Could not write cache entry", "Error", err)
+		return
+	}
+	tmp.Close()
+
+	var oldSize int64
+	if info, err := os.Stat(path); err == nil {
+		oldSize = info.Size()
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		log.Error("Could not write cache entry", "Error", err)
+		return
+	}
+
+	if s.size.Add(int64(len(data))-oldSize) > s.maxBytes && s.evicting.CompareAndSwap(false, true) {
+		go s.evict()
+	}
+}
+
+/**
+ * Returns the number of hits and misses since start.
+ *
+ * @return int64 Cache hits
+ * @return int64 Cache misses
+ */
+func (s *DiskStore) Stats() (int64, int64) {
+	return s.hits.Load(), s.misses.Load()
+}
+
+/**
+ * Starts a goroutine that removes expired results every
+ * ten minutes.
+ */
+func (s *DiskStore) StartCleanup() {
+	go func() {
+		for {
+			time.Sleep(10 * time.Minute)
+
+			s.mu.Lock()
+			files, err := s.files()
+			if err != nil {
+				log.Error("Could not list cache entries", "Error", err)
+			}
+			for _, file := range files {
+				var entry diskEntry
+				data, err := os.ReadFile(file.path)
+				if err != nil {
+					continue
+				}
+				if err := json.Unmarshal(data, &entry); err != nil || time.Now().Unix() >= entry.Expires {
+					s.remove(file.path, file.size)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}()
+}
+
+/**
+ * Removes the least recently used files until the store
+ * is down to 90% of its size limit.
+ */
+func (s *DiskStore) evict() {
+	defer s.evicting.Store(false)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, err := s.files()
+	if err != nil {
+		log.Error("Could not list cache entries", "Error", err)
+		return
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modified.Before(files[j].modified)
+	})
+
+	target := s.maxBytes / 10 * 9
+	for _, file := range files {
+		if s.size.Load() <= target {
+			break
+		}
+		s.remove(file.path, file.size)
+	}
+}
+
+/**
+ * Removes a file and subtracts its size from the total.
+ *
+ * @param path string File path
+ * @param size int64 Size of the file
+ */
+func (s *DiskStore) remove(path string, size int64) {
+	if err := os.Remove(path); err == nil {
+		s.size.Add(-size)
+	}
+}
+
+/**
+ * Lists the result files in the store.
+ *
+ * @return []diskFile Files in the store
+ * @return error Error object
+ */
+func (s *DiskStore) files() ([]diskFile, error) {
+	files := []diskFile{}
+	err := filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(path) != ".json" {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		files = append(files, diskFile{path: path, size: info.Size(), modified: info.ModTime()})
+		return nil
+	})
+	return files, err
+}
@@ -0,0 +1,76 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package cache
+
+import (
+	"time"
+
+	"github.com/karlseguin/ccache/v3"
+)
+
+/**
+ * Creates a new in-memory store.
+ *
+ * @param maxEntries int Max number of results kept
+ * @param ttl int Seconds results are kept
+ * @return *MemoryStore New MemoryStore instance
+ */
+func NewMemoryStore(maxEntries, ttl int) *MemoryStore {
+	return &MemoryStore{
+		lru: ccache.New(ccache.Configure[map[string]interface{}]().MaxSize(int64(maxEntries)).ItemsToPrune(uint32(max(maxEntries/10, 1)))),
+		ttl: time.Duration(ttl) * time.Second,
+	}
+}
+
+/**
+ * Looks up a result that hasn't expired yet.
+ *
+ * @param key string Cache key
+ * @return map[string]interface{} Cached response body
+ * @return bool Whether the key was found
+ */
+func (s *MemoryStore) Get(key string) (map[string]interface{}, bool) {
+	item := s.lru.Get(key)
+	if item == nil || item.Expired() {
+		s.misses.Add(1)
+		return nil, false
+	}
+
+	s.hits.Add(1)
+	return item.Value(), true
+}
+
+/**
+ * Stores a result until the TTL runs out or it is pushed
+ * out by newer ones.
+ *
+ * @param key string Cache key
+ * @param value map[string]interface{} Response body
+ */
+func (s *MemoryStore) Set(key string, value map[string]interface{}) {
+	s.lru.Set(key, value, s.ttl)
+}
+
+/**
+ * Returns the number of hits and misses since start.
+ *
+ * @return int64 Cache hits
+ * @return int64 Cache misses
+ */
+func (s *MemoryStore) Stats() (int64, int64) {
+	return s.hits.Load(), s.misses.Load()
+}
@@ -0,0 +1,122 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/karlseguin/ccache/v3"
+)
+
+/**
+ * Interface for storing execution results by key.
+ */
+type Store interface {
+	/**
+	 * Looks up a result that hasn't expired yet.
+	 *
+	 * @param key string Cache key
+	 * @return map[string]interface{} Cached response body
+	 * @return bool Whether the key was found
+	 */
+	Get(key string) (map[string]interface{}, bool)
+
+	/**
+	 * Stores a result until the TTL runs out or it is
+	 * evicted to make room.
+	 *
+	 * @param key string Cache key
+	 * @param value map[string]interface{} Response body
+	 */
+	Set(key string, value map[string]interface{})
+
+	/**
+	 * Returns the number of hits and misses since start.
+	 *
+	 * @return int64 Cache hits
+	 * @return int64 Cache misses
+	 */
+	Stats() (int64, int64)
+}
+
+/**
+ * Struct for an in-memory LRU store. Lost on restart.
+ *
+ * @field lru *ccache.Cache[map[string]interface{}] LRU
+ * @field ttl time.Duration Time results are kept
+ * @field hits atomic.Int64 Number of cache hits
+ * @field misses atomic.Int64 Number of cache misses
+ */
+type MemoryStore struct {
+	lru    *ccache.Cache[map[string]interface{}]
+	ttl    time.Duration
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+/**
+ * Struct for a store that keeps every result in its own
+ * file, so that results survive restarts. Files are
+ * spread over subdirectories named after the first two
+ * characters of their key.
+ *
+ * @field dir string Directory the files are kept in
+ * @field ttl time.Duration Time results are kept
+ * @field maxBytes int64 Total size of the files above
+ *   which the least recently used ones are evicted
+ * @field size atomic.Int64 Current total size of the files
+ * @field evicting atomic.Bool Whether an eviction is running
+ * @field mu sync.Mutex Mutex for evictions
+ * @field hits atomic.Int64 Number of cache hits
+ * @field misses atomic.Int64 Number of cache misses
+ */
+type DiskStore struct {
+	dir      string
+	ttl      time.Duration
+	maxBytes int64
+	size     atomic.Int64
+	evicting atomic.Bool
+	mu       sync.Mutex
+	hits     atomic.Int64
+	misses   atomic.Int64
+}
+
+/**
+ * Struct for a result as written to disk.
+ *
+ * @field Expires int64 Unix time the result expires at
+ * @field Value map[string]interface{} Response body
+ */
+type diskEntry struct {
+	Expires int64                  `json:"expires"`
+	Value   map[string]interface{} `json:"value"`
+}
+
+/**
+ * Struct for a file found in a DiskStore.
+ *
+ * @field path string File path
+ * @field size int64 Size of the file
+ * @field modified time.Time Time the file was last used
+ */
+type diskFile struct {
+	path     string
+	size     int64
+	modified time.Time
+}
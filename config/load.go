@@ -17,10 +17,14 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"math"
+
+	"whipcode/sandbox"
 	"whipcode/server"
 
 	"github.com/BurntSushi/toml"
-	"github.com/charmbracelet/log"
 )
 
 /**
@@ -28,25 +32,147 @@
  *
  * @param path string Path to the configuration file
  * @return *Config Configuration object
+ * @return error Error object
  */
-func LoadConfig(path string) *Config {
+func LoadConfig(path string) (*Config, error) {
 	var config Config
 	if _, err := toml.DecodeFile(path, &config); err != nil {
-		log.Fatal("Could not load config", "File", path, "Error", err)
+		return nil, err
 	}
-	return &config
+	return &config, nil
 }
 
 /**
- * Loads the language map from the given path.
+ * Validates the ceilings for the resource limits clients
+ * may request and converts them into limits. Memory, cpus
+ * and pids need a ceiling, since requested values are
+ * lowered to it and a limit of zero means no limit at all
+ * to the sandbox. An output ceiling of zero leaves output
+ * uncapped.
+ *
+ * @return sandbox.Limits Ceilings
+ * @return error Error object
+ */
+func (config *Config) Ceilings() (sandbox.Limits, error) {
+	memory, err := sandbox.ParseSize(config.MaxMemory)
+	if err != nil {
+		return sandbox.Limits{}, fmt.Errorf("invalid value for maxMemory: %w", err)
+	}
+	if memory < sandbox.MinMemory {
+		return sandbox.Limits{}, errors.New("invalid value for maxMemory, must be at least 6m")
+	}
+	if !(config.MaxCPUs > 0) || math.IsInf(config.MaxCPUs, 1) {
+		return sandbox.Limits{}, errors.New("invalid value for maxCpus, must be a positive number")
+	}
+	if config.MaxPids <= 0 {
+		return sandbox.Limits{}, errors.New("invalid value for maxPids, must be a positive integer")
+	}
+	if config.MaxOutputBytes < 0 {
+		return sandbox.Limits{}, errors.New("invalid value for maxOutputBytes, must not be negative")
+	}
+
+	return sandbox.Limits{
+		Memory: memory,
+		CPUs:   config.MaxCPUs,
+		Pids:   config.MaxPids,
+		Output: int64(config.MaxOutputBytes),
+	}, nil
+}
+
+/**
+ * Loads the language map from the given path. Resource
+ * limits that are left out fall back to the defaults
+ * below, every entry is validated before it is used.
  *
  * @param path string Path to the language map file
- * @return *server.LangMap Language map object
+ * @return server.LangMap Language map object
+ * @return error Error object
+ */
+func LoadLangs(path string) (server.LangMap, error) {
+	var entries map[string]langEntry
+	if _, err := toml.DecodeFile(path, &entries); err != nil {
+		return nil, err
+	}
+
+	langs := make(server.LangMap, len(entries))
+	for id, entry := range entries {
+		lang, err := buildLang(entry)
+		if err != nil {
+			return nil, fmt.Errorf("language %s: %w", id, err)
+		}
+		langs[id] = lang
+	}
+
+	return langs, nil
+}
+
+/**
+ * Validates a language map entry and converts it into a
+ * language with its resource limits.
+ *
+ * @param entry langEntry Entry from the language map
+ * @return server.Language Language object
+ * @return error Error object
  */
-func LoadLangs(path string) *server.LangMap {
-	var langs server.LangMap
-	if _, err := toml.DecodeFile(path, &langs); err != nil {
-		log.Fatal("Could not load language map", "File", path, "Error", err)
+func buildLang(entry langEntry) (server.Language, error) {
+	if entry.Entry == "" || entry.Ext == "" {
+		return server.Language{}, errors.New("entry and ext are required")
+	}
+
+	if entry.Run == "" {
+		entry.Run = entry.Entry
+	}
+	if entry.Memory == "" {
+		entry.Memory = defaultMemory
+	}
+	if entry.Tmpfs == "" {
+		entry.Tmpfs = defaultTmpfs
+	}
+	if entry.CPUs == 0 {
+		entry.CPUs = defaultCPUs
+	}
+	if entry.Pids == 0 {
+		entry.Pids = defaultPids
+	}
+
+	memory, err := sandbox.ParseSize(entry.Memory)
+	if err != nil {
+		return server.Language{}, fmt.Errorf("memory: %w", err)
 	}
-	return &langs
+	if memory < sandbox.MinMemory {
+		return server.Language{}, errors.New("memory must be at least 6m")
+	}
+
+	tmpfs, err := sandbox.ParseSize(entry.Tmpfs)
+	if err != nil {
+		return server.Language{}, fmt.Errorf("tmpfs: %w", err)
+	}
+
+	if entry.CPUs < 0 {
+		return server.Language{}, errors.New("cpus must be positive")
+	}
+	if entry.Pids < 0 {
+		return server.Language{}, errors.New("pids must be positive")
+	}
+	if entry.Timeout < 0 {
+		return server.Language{}, errors.New("timeout must not be negative")
+	}
+	if entry.Pool < 0 {
+		return server.Language{}, errors.New("pool must not be negative")
+	}
+
+	return server.Language{
+		Entry:      entry.Entry,
+		Ext:        entry.Ext,
+		Compile:    entry.Compile,
+		Run:        entry.Run,
+		MaxTimeout: entry.Timeout,
+		PoolSize:   entry.Pool,
+		Limits: sandbox.Limits{
+			Memory: memory,
+			CPUs:   entry.CPUs,
+			Pids:   entry.Pids,
+			Tmpfs:  tmpfs,
+		},
+	}, nil
 }
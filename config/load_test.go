@@ -0,0 +1,62 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package config
+
+import (
+	"math"
+	"testing"
+
+	"whipcode/sandbox"
+)
+
+func TestCeilings(t *testing.T) {
+	valid := Config{MaxMemory: "2g", MaxCPUs: 2, MaxPids: 128, MaxOutputBytes: 1000}
+	ceilings, err := valid.Ceilings()
+	if err != nil {
+		t.Fatalf("Ceilings = %v", err)
+	}
+	if want := (sandbox.Limits{Memory: 2 << 30, CPUs: 2, Pids: 128, Output: 1000}); ceilings != want {
+		t.Errorf("Ceilings = %+v, want %+v", ceilings, want)
+	}
+
+	uncapped := valid
+	uncapped.MaxOutputBytes = 0
+	if _, err := uncapped.Ceilings(); err != nil {
+		t.Errorf("Ceilings without an output ceiling = %v", err)
+	}
+
+	// A ceiling of zero would lower every requested limit
+	// to zero, which the sandbox takes as no limit
+	for name, modify := range map[string]func(*Config){
+		"missing maxMemory":  func(c *Config) { c.MaxMemory = "" },
+		"zero maxMemory":     func(c *Config) { c.MaxMemory = "0" },
+		"small maxMemory":    func(c *Config) { c.MaxMemory = "1m" },
+		"missing maxCpus":    func(c *Config) { c.MaxCPUs = 0 },
+		"negative maxCpus":   func(c *Config) { c.MaxCPUs = -1 },
+		"infinite maxCpus":   func(c *Config) { c.MaxCPUs = math.Inf(1) },
+		"NaN maxCpus":        func(c *Config) { c.MaxCPUs = math.NaN() },
+		"missing maxPids":    func(c *Config) { c.MaxPids = 0 },
+		"negative maxPids":   func(c *Config) { c.MaxPids = -1 },
+		"negative maxOutput": func(c *Config) { c.MaxOutputBytes = -1 },
+	} {
+		config := valid
+		modify(&config)
+		if ceilings, err := config.Ceilings(); err == nil {
+			t.Errorf("%s: Ceilings = %+v, want an error", name, ceilings)
+		}
+	}
+}
@@ -16,6 +16,16 @@
 
 package config
 
+/**
+ * Resource limits for languages that don't set their own.
+ */
+const (
+	defaultMemory = "512m"
+	defaultTmpfs  = "64m"
+	defaultCPUs   = 1.0
+	defaultPids   = 32
+)
+
 /**
  * Struct for defining configuration options.
  *
@@ -23,32 +33,147 @@
  * @field Addr string Address to listen on
  * @field MaxBytes int Max bytes to accept
  * @field Proxy string Reverse proxy address
+ * @field LogFormat string Log format, text or json
+ * @field AuditLog string Audit log file, empty to disable
  * @field TLS bool Enable tls
  * @field TLSDir string Directory with cert and key
  * @field Ping bool Enable /ping endpoint
+ * @field Jobs bool Enable /jobs endpoints
+ * @field JobTTL int Seconds finished jobs are kept
+ * @field MaxJobs int Max jobs running at once
+ * @field MaxJobsPerKey int Max jobs of a key running at
+ *   once
+ * @field Sessions bool Enable /session endpoint
+ * @field IdleTimeout int Idle timeout for sessions
+ * @field SessionOrigins []string Origins allowed to open
+ *   sessions besides the server's own
+ * @field Metrics bool Enable /metrics endpoint
+ * @field MetricsAddr string Separate address for /metrics
+ * @field DrainTimeout int Seconds to wait for executions
+ *   on shutdown
  * @field LangMap string Path to the language map
+ * @field Backend string Sandbox backend to use
  * @field PodmanPath string Path to podman
+ * @field BwrapPath string Path to bwrap
+ * @field SystemdRunPath string Path to systemd-run
+ * @field SystemctlPath string Path to systemctl
+ * @field RootfsDir string Root filesystems for bwrap
+ * @field BwrapTmpDir string Tmpfs for the /tmp of bwrap
+ *   sandboxes
  * @field Timeout int Timeout for executions
+ * @field CompileTimeout int Timeout for compilation
+ * @field BatchTimeout int Total timeout for test cases
+ * @field MaxTestCases int Max test cases per request
+ * @field MaxMemory string Ceiling for memory_limit
+ * @field MaxCPUs float64 Ceiling for cpu_limit
+ * @field MaxPids int Ceiling for pids_limit
+ * @field MaxOutputBytes int Ceiling for max_output_bytes
+ * @field KillOnOutputLimit bool Kill phases that exceed
+ *   the output limit
+ * @field MaxConcurrent int Max concurrent executions
+ * @field MaxQueued int Max executions waiting for a slot
+ * @field QueueTimeout int Max seconds to wait for a slot
  * @field Key string Master key file
+ * @field Keys string Key registry file
+ * @field AuthMaxFailures int Failed authentication attempts
+ *   that get a client banned, zero to disable bans
+ * @field AuthFailureWindow int Seconds failed attempts are
+ *   counted in
+ * @field AuthBanTime int Seconds a ban lasts
+ * @field SignatureMaxAge int Max age of signed requests in
+ *   seconds, zero to disable signed requests
  * @field Cache bool Enable execution cache
+ * @field CacheStore string Where cached results are kept,
+ *   memory or disk
+ * @field CacheDir string Directory for the disk store
+ * @field CacheTTL int Seconds results are cached
+ * @field CacheMaxEntries int Max results in memory
+ * @field CacheMaxBytes string Max size of the disk store
  * @field Standalone bool Enable rate limiting
  * @field Burst int Burst for the rate limiter
  * @field Refill int Refill for the rate limiter
  */
 type Config struct {
-	Port       int
-	Addr       string
-	MaxBytes   int
-	Proxy      string
-	TLS        bool
-	TLSDir     string
-	Ping       bool
-	LangMap    string
-	PodmanPath string
-	Timeout    int
-	Key        string
-	Cache      bool
-	Standalone bool
-	Burst      int
-	Refill     int
+	Port              int
+	Addr              string
+	MaxBytes          int
+	Proxy             string
+	LogFormat         string
+	AuditLog          string
+	TLS               bool
+	TLSDir            string
+	Ping              bool
+	Jobs              bool
+	JobTTL            int
+	MaxJobs           int
+	MaxJobsPerKey     int
+	Sessions          bool
+	IdleTimeout       int
+	SessionOrigins    []string
+	Metrics           bool
+	MetricsAddr       string
+	DrainTimeout      int
+	LangMap           string
+	Backend           string
+	PodmanPath        string
+	BwrapPath         string
+	SystemdRunPath    string
+	SystemctlPath     string
+	RootfsDir         string
+	BwrapTmpDir       string
+	Timeout           int
+	CompileTimeout    int
+	BatchTimeout      int
+	MaxTestCases      int
+	MaxMemory         string
+	MaxCPUs           float64
+	MaxPids           int
+	MaxOutputBytes    int
+	KillOnOutputLimit bool
+	MaxConcurrent     int
+	MaxQueued         int
+	QueueTimeout      int
+	Key               string
+	Keys              string
+	AuthMaxFailures   int
+	AuthFailureWindow int
+	AuthBanTime       int
+	SignatureMaxAge   int
+	Cache             bool
+	CacheStore        string
+	CacheDir          string
+	CacheTTL          int
+	CacheMaxEntries   int
+	CacheMaxBytes     string
+	Standalone        bool
+	Burst             int
+	Refill            int
+}
+
+/**
+ * Struct for a language as written in the language map,
+ * before it is validated.
+ *
+ * @field Entry string Entry script, also names the image
+ * @field Ext string File extension
+ * @field Compile string Compile script (optional)
+ * @field Run string Run script (optional)
+ * @field Memory string Memory limit, e.g. "512m"
+ * @field CPUs float64 Number of CPUs
+ * @field Pids int Max number of processes
+ * @field Tmpfs string Size of /tmp, e.g. "64m"
+ * @field Timeout int Max timeout in seconds (optional)
+ * @field Pool int Number of warm containers (optional)
+ */
+type langEntry struct {
+	Entry   string  `toml:"entry"`
+	Ext     string  `toml:"ext"`
+	Compile string  `toml:"compile"`
+	Run     string  `toml:"run"`
+	Memory  string  `toml:"memory"`
+	CPUs    float64 `toml:"cpus"`
+	Pids    int     `toml:"pids"`
+	Tmpfs   string  `toml:"tmpfs"`
+	Timeout int     `toml:"timeout"`
+	Pool    int     `toml:"pool"`
 }
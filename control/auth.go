@@ -17,54 +17,258 @@
 package control
 
 import (
+	"container/list"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"os"
+	"slices"
 	"strings"
 
 	"github.com/charmbracelet/log"
-	"golang.org/x/crypto/argon2"
 )
 
 /**
- * Checks if the user key is valid. Runs argon2id on
- * requests until a valid request is made, then caches
- * the key.
+ * ID of the key loaded from the legacy master key file.
+ */
+const MasterKeyID = "master"
+
+/**
+ * Max number of verified user keys remembered by a key
+ * store. The least recently used ones are forgotten and
+ * have to go through argon2id again.
+ */
+const maxVerifiedKeys = 1024
+
+/**
+ * Checks if the user key is valid and returns the key it
+ * belongs to. Keys are sent as <id>.<secret>, the master
+ * key from the legacy key file may also be sent as is.
+ * Runs argon2id on user keys that weren't verified
+ * recently. Verified user keys are remembered by their
+ * SHA-256 hash, so the secrets themselves are not kept.
+ *
+ * Both the key ID and the master key are always checked,
+ * against a decoy if they don't exist, so that the time
+ * taken doesn't reveal which key IDs exist.
  *
  * @param userKey string User key
- * @param serverKey []string Server key
+ * @return *Key Matching key, nil if there is none. The key
+ *   may be disabled
+ */
+func (ks *KeyStore) CheckKey(userKey string) *Key {
+	sum := sha256.Sum256([]byte(userKey))
+	if key := ks.lookupVerified(sum); key != nil {
+		return key
+	}
+
+	id, secret, _ := strings.Cut(userKey, ".")
+	key, exists := ks.keys[id]
+	if !exists {
+		key = ks.decoy
+	}
+	master, exists := ks.keys[MasterKeyID]
+	if !exists {
+		master = ks.decoy
+	}
+
+	var match *Key
+	keyValid := key.verify(secret)
+	masterValid := master.verify(userKey)
+	switch {
+	case keyValid && key != ks.decoy:
+		match = key
+	case masterValid && master != ks.decoy:
+		match = master
+	}
+
+	if match != nil {
+		ks.storeVerified(sum, match)
+	}
+	return match
+}
+
+/**
+ * Returns the key of a recently verified user key and marks
+ * it as used.
+ *
+ * @param sum [32]byte SHA-256 hash of the user key
+ * @return *Key Key, nil if the user key wasn't verified
+ *   recently
  */
-func (ks *KeyStore) CheckKey(userKey string, serverKey []string) bool {
-	if cachedKey, ok := ks.cachedKey.Load().(string); ok && userKey == cachedKey {
-		return true
+func (ks *KeyStore) lookupVerified(sum [32]byte) *Key {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	element, exists := ks.verified[sum]
+	if !exists {
+		return nil
 	}
+	ks.verifiedOrder.MoveToFront(element)
+	return element.Value.(*verifiedKey).key
+}
+
+/**
+ * Remembers a verified user key, forgetting the least
+ * recently used one if there are too many.
+ *
+ * @param sum [32]byte SHA-256 hash of the user key
+ * @param key *Key Key it belongs to
+ */
+func (ks *KeyStore) storeVerified(sum [32]byte, key *Key) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
 
-	hash := argon2.IDKey([]byte(userKey), []byte(serverKey[1]), 1, 4096, 1, 32)
-	if hex.EncodeToString(hash) == strings.TrimSpace(serverKey[0]) {
-		ks.cachedKey.Store(userKey)
-		return true
+	if _, exists := ks.verified[sum]; exists {
+		return
 	}
+	ks.verified[sum] = ks.verifiedOrder.PushFront(&verifiedKey{sum: sum, key: key})
 
-	return false
+	if ks.verifiedOrder.Len() > maxVerifiedKeys {
+		oldest := ks.verifiedOrder.Remove(ks.verifiedOrder.Back()).(*verifiedKey)
+		delete(ks.verified, oldest.sum)
+	}
+}
+
+/**
+ * Checks the secret against the hash of the key, in
+ * constant time.
+ *
+ * @param secret string Secret part of the user key
+ * @return bool True if the secret is valid
+ */
+func (key *Key) verify(secret string) bool {
+	return subtle.ConstantTimeCompare(hashSecret(secret, key.salt), key.hash) == 1
 }
 
 /**
- * Initializes the key store.
+ * Checks if the key may run the given language. Keys
+ * without a language list may run all of them.
+ *
+ * @param entry string Entry of the language
+ * @return bool True if the language is allowed
+ */
+func (key *Key) AllowsLanguage(entry string) bool {
+	return len(key.Languages) == 0 || slices.Contains(key.Languages, entry)
+}
+
+/**
+ * Reads the hash and salt of the master key from the
+ * legacy key file.
  *
  * @param keyFile string Key file
- * @return *KeyStore Key store
- * @return []string Key and salt
+ * @return *Key Master key
+ * @return error Error object
  */
-func InitializeKeystore(keyFile string) (*KeyStore, []string) {
+func loadMasterKey(keyFile string) (*Key, error) {
 	file, err := os.ReadFile(keyFile)
 	if err != nil {
-		log.Fatal("Could not read master key", "File", keyFile, "Error", err)
+		return nil, err
 	}
 
 	keyAndSalt := strings.Split(string(file), "\n")
 	if len(keyAndSalt) != 2 || len(keyAndSalt[1]) < 1 {
-		log.Fatal("Invalid master key format", "File", keyFile)
+		return nil, errors.New("invalid master key format")
+	}
+
+	hash, err := hex.DecodeString(strings.TrimSpace(keyAndSalt[0]))
+	if err != nil || len(hash) != 32 {
+		return nil, errors.New("invalid master key format")
+	}
+
+	return &Key{
+		ID:      MasterKeyID,
+		Label:   "Master key",
+		Enabled: true,
+		hash:    hash,
+		salt:    keyAndSalt[1],
+	}, nil
+}
+
+/**
+ * Reads the keys of the key registry and validates them.
+ *
+ * @param registryFile string Key registry file
+ * @return []*Key Keys
+ * @return error Error object
+ */
+func loadRegistry(registryFile string) ([]*Key, error) {
+	registry, err := ReadRegistry(registryFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := registry.Validate(); err != nil {
+		return nil, err
+	}
+
+	keys := make([]*Key, 0, len(registry.Keys))
+	for _, entry := range registry.Keys {
+		hash, _ := hex.DecodeString(entry.Hash)
+		keys = append(keys, &Key{
+			ID:         entry.ID,
+			Label:      entry.Label,
+			Languages:  entry.Languages,
+			MaxTimeout: entry.MaxTimeout,
+			Enabled:    entry.Enabled == nil || *entry.Enabled,
+			hash:       hash,
+			salt:       entry.Salt,
+		})
+		if entry.SigningSecret != "" {
+			keys[len(keys)-1].signingSecret = []byte(entry.SigningSecret)
+		}
+	}
+
+	return keys, nil
+}
+
+/**
+ * Initializes the key store with the master key from the
+ * legacy key file and the keys of the key registry. Either
+ * file may be missing, as long as there is a key.
+ *
+ * @param keyFile string Master key file
+ * @param registryFile string Key registry file
+ * @return *KeyStore Key store
+ * @return error Error object
+ */
+func InitializeKeystore(keyFile, registryFile string) (*KeyStore, error) {
+	decoyHash := make([]byte, 32)
+	if _, err := rand.Read(decoyHash); err != nil {
+		return nil, err
+	}
+
+	keyStore := KeyStore{
+		keys:          make(map[string]*Key),
+		verified:      make(map[[32]byte]*list.Element),
+		verifiedOrder: list.New(),
+		decoy:         &Key{ID: "decoy", hash: decoyHash, salt: hex.EncodeToString(decoyHash[:16])},
+	}
+
+	master, err := loadMasterKey(keyFile)
+	switch {
+	case err == nil:
+		keyStore.keys[MasterKeyID] = master
+	case !os.IsNotExist(err):
+		return nil, fmt.Errorf("master key %s: %w", keyFile, err)
+	}
+
+	if registryFile != "" {
+		keys, err := loadRegistry(registryFile)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("key registry %s: %w", registryFile, err)
+		}
+		for _, key := range keys {
+			keyStore.keys[key.ID] = key
+		}
+	}
+
+	if len(keyStore.keys) == 0 {
+		return nil, errors.New("no keys found, generate one with --gen-key or keys add")
 	}
-	keyStore := KeyStore{}
+	log.Info("Loaded keys", "Count", len(keyStore.keys))
 
-	return &keyStore, keyAndSalt
+	return &keyStore, nil
 }
@@ -0,0 +1,182 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package control
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+/**
+ * Creates a key store with a master key, an enabled key
+ * alpha that may only run python, and a disabled key beta.
+ *
+ * @param t *testing.T Test
+ * @return *KeyStore Key store
+ */
+func newTestKeyStore(t *testing.T) *KeyStore {
+	t.Helper()
+	dir := t.TempDir()
+
+	masterFile := filepath.Join(dir, ".masterkey")
+	if err := os.WriteFile(masterFile, []byte(HashSecret("master-secret", "master-salt")+"\nmaster-salt"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	disabled := false
+	registry := Registry{Keys: []RegistryEntry{
+		{
+			ID:         "alpha",
+			Hash:       HashSecret("alpha-secret", "alpha-salt"),
+			Salt:       "alpha-salt",
+			Languages:  []string{"python"},
+			MaxTimeout: 2,
+		},
+		{
+			ID:      "beta",
+			Hash:    HashSecret("beta-secret", "beta-salt"),
+			Salt:    "beta-salt",
+			Enabled: &disabled,
+		},
+	}}
+	registryFile := filepath.Join(dir, "keys.toml")
+	if err := registry.Write(registryFile); err != nil {
+		t.Fatal(err)
+	}
+
+	ks, err := InitializeKeystore(masterFile, registryFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ks
+}
+
+func TestCheckKey(t *testing.T) {
+	ks := newTestKeyStore(t)
+
+	tests := []struct {
+		userKey string
+		want    string
+	}{
+		{"alpha.alpha-secret", "alpha"},
+		{"beta.beta-secret", "beta"},
+		{"master-secret", MasterKeyID},
+		{"master.master-secret", MasterKeyID},
+		{"alpha.wrong", ""},
+		{"alpha.beta-secret", ""},
+		{"gamma.alpha-secret", ""},
+		{"alpha-secret", ""},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		key := ks.CheckKey(test.userKey)
+		got := ""
+		if key != nil {
+			got = key.ID
+		}
+		if got != test.want {
+			t.Errorf("CheckKey(%q) = %q, want %q", test.userKey, got, test.want)
+		}
+	}
+
+	if key := ks.CheckKey("alpha.alpha-secret"); key.MaxTimeout != 2 || !key.Enabled {
+		t.Errorf("alpha has max timeout %d, enabled %t, want 2, true", key.MaxTimeout, key.Enabled)
+	}
+	if key := ks.CheckKey("beta.beta-secret"); key.Enabled {
+		t.Error("disabled key is enabled")
+	}
+}
+
+func TestInitializeKeystore(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := InitializeKeystore(filepath.Join(dir, ".masterkey"), filepath.Join(dir, "keys.toml")); err == nil {
+		t.Error("key store without keys initialized")
+	}
+
+	invalid := filepath.Join(dir, "invalid.toml")
+	if err := os.WriteFile(invalid, []byte("[[keys]]\nid = \"a.b\"\nhash = \"00\"\nsalt = \"s\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := InitializeKeystore(filepath.Join(dir, ".masterkey"), invalid); err == nil {
+		t.Error("key store with an invalid registry initialized")
+	}
+}
+
+func TestAllowsLanguage(t *testing.T) {
+	ks := newTestKeyStore(t)
+
+	if !ks.keys["alpha"].AllowsLanguage("python") || ks.keys["alpha"].AllowsLanguage("nodejs") {
+		t.Error("alpha should only allow python")
+	}
+	if !ks.keys["beta"].AllowsLanguage("nodejs") {
+		t.Error("keys without a language list should allow every language")
+	}
+}
+
+func TestCheckKeyVerified(t *testing.T) {
+	ks := newTestKeyStore(t)
+
+	for range 2 {
+		if key := ks.CheckKey("alpha.alpha-secret"); key == nil || key.ID != "alpha" {
+			t.Fatalf("CheckKey = %v, want alpha", key)
+		}
+		if ks.CheckKey("alpha.wrong") != nil {
+			t.Fatal("wrong secret accepted")
+		}
+	}
+	if len(ks.verified) != 1 {
+		t.Errorf("%d verified keys remembered, want 1", len(ks.verified))
+	}
+}
+
+func TestCheckKeyDecoy(t *testing.T) {
+	ks := newTestKeyStore(t)
+
+	for _, userKey := range []string{"decoy.", "decoy.decoy", "decoy"} {
+		if key := ks.CheckKey(userKey); key != nil {
+			t.Errorf("CheckKey(%q) = %q, want no key", userKey, key.ID)
+		}
+	}
+
+	// The decoy stands in for a missing master key as well
+	delete(ks.keys, MasterKeyID)
+	if key := ks.CheckKey("master-secret"); key != nil {
+		t.Errorf("CheckKey without a master key = %q, want no key", key.ID)
+	}
+}
+
+func TestVerifiedKeysEviction(t *testing.T) {
+	ks := newTestKeyStore(t)
+	key := ks.keys["alpha"]
+
+	sum := func(i int) [32]byte { return [32]byte{byte(i), byte(i >> 8)} }
+	for i := range maxVerifiedKeys + 10 {
+		ks.storeVerified(sum(i), key)
+	}
+	if ks.verifiedOrder.Len() != maxVerifiedKeys || len(ks.verified) != maxVerifiedKeys {
+		t.Fatalf("%d verified keys remembered, want %d", len(ks.verified), maxVerifiedKeys)
+	}
+	if ks.lookupVerified(sum(0)) != nil {
+		t.Error("least recently used key was not forgotten")
+	}
+	if ks.lookupVerified(sum(maxVerifiedKeys+9)) != key {
+		t.Error("most recently used key was forgotten")
+	}
+}
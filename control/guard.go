@@ -0,0 +1,161 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package control
+
+import (
+	"time"
+)
+
+/**
+ * Creates a new guard against brute forcing keys. Returns
+ * nil if maxFailures is zero, which disables it.
+ *
+ * @param maxFailures int Failures that get a client banned
+ * @param window int Seconds failures are counted in
+ * @param banTime int Seconds a ban lasts
+ * @return *AuthGuard Guard object
+ */
+func NewAuthGuard(maxFailures, window, banTime int) *AuthGuard {
+	if maxFailures <= 0 {
+		return nil
+	}
+
+	return &AuthGuard{
+		clients:     make(map[string]*authClient),
+		maxFailures: maxFailures,
+		window:      time.Duration(window) * time.Second,
+		banTime:     time.Duration(banTime) * time.Second,
+	}
+}
+
+/**
+ * Returns how long the client is still banned for. Safe
+ * to call on a nil guard.
+ *
+ * @param ip string Address of the client
+ * @return time.Duration Time left, zero if the client isn't
+ *   banned
+ */
+func (g *AuthGuard) Banned(ip string) time.Duration {
+	if g == nil {
+		return 0
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	client, exists := g.clients[ip]
+	if !exists {
+		return 0
+	}
+	return max(time.Until(client.bannedUntil), 0)
+}
+
+/**
+ * Records a failed attempt of the client, and bans it once
+ * it reaches the max failures within the window. Safe to
+ * call on a nil guard.
+ *
+ * @param ip string Address of the client
+ * @return int Failures in the current window
+ * @return bool True if this failure got the client banned
+ */
+func (g *AuthGuard) Fail(ip string) (int, bool) {
+	if g == nil {
+		return 0, false
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	client, exists := g.clients[ip]
+	if !exists {
+		client = &authClient{windowStart: now}
+		g.clients[ip] = client
+	} else if now.Sub(client.windowStart) >= g.window {
+		client.failures = 0
+		client.windowStart = now
+	}
+
+	client.failures++
+	if client.failures < g.maxFailures {
+		return client.failures, false
+	}
+
+	failures := client.failures
+	client.failures = 0
+	client.windowStart = now
+	client.bannedUntil = now.Add(g.banTime)
+	return failures, true
+}
+
+/**
+ * Forgets the failed attempts of a client after it
+ * authenticated. Safe to call on a nil guard.
+ *
+ * @param ip string Address of the client
+ */
+func (g *AuthGuard) Succeed(ip string) {
+	if g == nil {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if client, exists := g.clients[ip]; exists && client.bannedUntil.Before(time.Now()) {
+		delete(g.clients, ip)
+	}
+}
+
+/**
+ * Starts a cleanup routine to remove clients whose window
+ * and ban are over. Run as a goroutine. Does nothing on a
+ * nil guard.
+ */
+func (g *AuthGuard) StartCleanup() {
+	if g == nil {
+		return
+	}
+
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			now := time.Now()
+			g.mu.Lock()
+			for ip, client := range g.clients {
+				if now.Sub(client.windowStart) >= g.window && now.After(client.bannedUntil) {
+					delete(g.clients, ip)
+				}
+			}
+			g.mu.Unlock()
+		}
+	}()
+}
+
+/**
+ * Returns how long bans last.
+ *
+ * @return time.Duration Ban time, zero on a nil guard
+ */
+func (g *AuthGuard) BanTime() time.Duration {
+	if g == nil {
+		return 0
+	}
+	return g.banTime
+}
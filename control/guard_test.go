@@ -0,0 +1,90 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package control
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAuthGuardDisabled(t *testing.T) {
+	g := NewAuthGuard(0, 300, 900)
+	if g != nil {
+		t.Fatal("guard with 0 max failures should be nil")
+	}
+
+	for range 10 {
+		if _, banned := g.Fail("192.0.2.1"); banned {
+			t.Fatal("nil guard banned a client")
+		}
+	}
+	g.Succeed("192.0.2.1")
+	if g.Banned("192.0.2.1") != 0 || g.BanTime() != 0 {
+		t.Error("nil guard reports a ban")
+	}
+}
+
+func TestAuthGuardBan(t *testing.T) {
+	g := NewAuthGuard(3, 300, 900)
+
+	for i := 1; i < 3; i++ {
+		if failures, banned := g.Fail("192.0.2.1"); failures != i || banned {
+			t.Fatalf("Fail = %d, %t, want %d, false", failures, banned, i)
+		}
+	}
+	if g.Banned("192.0.2.1") != 0 {
+		t.Fatal("client banned before reaching the max failures")
+	}
+
+	if failures, banned := g.Fail("192.0.2.1"); failures != 3 || !banned {
+		t.Fatalf("Fail = %d, %t, want 3, true", failures, banned)
+	}
+	if remaining := g.Banned("192.0.2.1"); remaining <= 0 || remaining > 900*time.Second {
+		t.Errorf("Banned = %s, want up to 15m", remaining)
+	}
+	if g.Banned("192.0.2.2") != 0 {
+		t.Error("other client banned")
+	}
+
+	g.Succeed("192.0.2.1")
+	if g.Banned("192.0.2.1") == 0 {
+		t.Error("a success lifted the ban")
+	}
+}
+
+func TestAuthGuardSucceed(t *testing.T) {
+	g := NewAuthGuard(3, 300, 900)
+
+	g.Fail("192.0.2.1")
+	g.Fail("192.0.2.1")
+	g.Succeed("192.0.2.1")
+	if failures, banned := g.Fail("192.0.2.1"); failures != 1 || banned {
+		t.Errorf("Fail after a success = %d, %t, want 1, false", failures, banned)
+	}
+}
+
+func TestAuthGuardWindow(t *testing.T) {
+	g := NewAuthGuard(3, 300, 900)
+
+	g.Fail("192.0.2.1")
+	g.Fail("192.0.2.1")
+	g.clients["192.0.2.1"].windowStart = time.Now().Add(-301 * time.Second)
+
+	if failures, banned := g.Fail("192.0.2.1"); failures != 1 || banned {
+		t.Errorf("Fail after the window = %d, %t, want 1, false", failures, banned)
+	}
+}
@@ -0,0 +1,162 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package control
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/BurntSushi/toml"
+	"golang.org/x/crypto/argon2"
+)
+
+/**
+ * Pattern key IDs must match. IDs can't contain a dot,
+ * as it separates the ID from the secret.
+ */
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+/**
+ * Hashes a secret with argon2id, the same way the master
+ * key is hashed.
+ *
+ * @param secret string Secret
+ * @param salt string Salt
+ * @return string Hex encoded hash
+ */
+func HashSecret(secret, salt string) string {
+	return hex.EncodeToString(hashSecret(secret, salt))
+}
+
+/**
+ * Hashes a secret with argon2id.
+ *
+ * @param secret string Secret
+ * @param salt string Salt
+ * @return []byte Hash
+ */
+func hashSecret(secret, salt string) []byte {
+	return argon2.IDKey([]byte(secret), []byte(salt), 1, 4096, 1, 32)
+}
+
+/**
+ * Reads the key registry from the given path.
+ *
+ * @param path string Key registry file
+ * @return *Registry Key registry
+ * @return error Error object
+ */
+func ReadRegistry(path string) (*Registry, error) {
+	var registry Registry
+	if _, err := toml.DecodeFile(path, &registry); err != nil {
+		return nil, err
+	}
+	return &registry, nil
+}
+
+/**
+ * Checks that every key of the registry is well formed
+ * and that no ID is used twice.
+ *
+ * @return error Error object
+ */
+func (registry *Registry) Validate() error {
+	seen := make(map[string]bool, len(registry.Keys))
+	for _, entry := range registry.Keys {
+		if !keyIDPattern.MatchString(entry.ID) {
+			return fmt.Errorf("invalid key id %q", entry.ID)
+		}
+		if entry.ID == MasterKeyID {
+			return fmt.Errorf("key id %q is reserved for the master key", entry.ID)
+		}
+		if seen[entry.ID] {
+			return fmt.Errorf("duplicate key id %q", entry.ID)
+		}
+		seen[entry.ID] = true
+
+		if _, err := hex.DecodeString(entry.Hash); err != nil || len(entry.Hash) != 64 {
+			return fmt.Errorf("key %s: hash must be 64 hex characters", entry.ID)
+		}
+		if entry.Salt == "" {
+			return fmt.Errorf("key %s: missing salt", entry.ID)
+		}
+		if entry.MaxTimeout < 0 {
+			return fmt.Errorf("key %s: max_timeout must not be negative", entry.ID)
+		}
+		if entry.SigningSecret != "" && len(entry.SigningSecret) < 32 {
+			return fmt.Errorf("key %s: signing_secret must be at least 32 characters", entry.ID)
+		}
+	}
+	return nil
+}
+
+/**
+ * Returns the key with the given ID.
+ *
+ * @param id string Key ID
+ * @return *RegistryEntry Key, nil if there is none
+ */
+func (registry *Registry) Find(id string) *RegistryEntry {
+	for i := range registry.Keys {
+		if registry.Keys[i].ID == id {
+			return &registry.Keys[i]
+		}
+	}
+	return nil
+}
+
+/**
+ * Validates the registry and writes it to the given path.
+ * The registry is written to a temporary file next to it
+ * first and renamed over it, so a running server never
+ * reads a half written file.
+ *
+ * @param path string Key registry file
+ * @return error Error object
+ */
+func (registry *Registry) Write(path string) error {
+	if err := registry.Validate(); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := toml.NewEncoder(tmp).Encode(registry); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
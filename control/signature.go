@@ -0,0 +1,167 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package control
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+/**
+ * Builds the string a signed request is signed over. Every
+ * part is on its own line:
+ *
+ *   METHOD
+ *   /path?query
+ *   key id
+ *   unix timestamp
+ *   nonce
+ *   hex encoded SHA-256 hash of the body
+ *
+ * @param method string HTTP method
+ * @param uri string Path and query of the request
+ * @param keyID string ID of the signing key
+ * @param timestamp string Unix timestamp in seconds
+ * @param nonce string Nonce of the request
+ * @param body []byte Request body
+ * @return []byte String to sign
+ */
+func SigningString(method, uri, keyID, timestamp, nonce string, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+	return []byte(strings.Join([]string{
+		method,
+		uri,
+		keyID,
+		timestamp,
+		nonce,
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n"))
+}
+
+/**
+ * Returns the key with the given ID if it can sign
+ * requests.
+ *
+ * @param id string Key ID
+ * @return *Key Key, nil if there is none or it can't sign
+ */
+func (ks *KeyStore) SigningKey(id string) *Key {
+	if key, exists := ks.keys[id]; exists && key.signingSecret != nil {
+		return key
+	}
+	return nil
+}
+
+/**
+ * Checks a signature made with the signing secret of the
+ * key, in constant time.
+ *
+ * @param message []byte Signed string
+ * @param signature string Hex encoded HMAC-SHA256
+ * @return bool True if the signature is valid
+ */
+func (key *Key) VerifySignature(message []byte, signature string) bool {
+	signatureBytes, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, key.signingSecret)
+	mac.Write(message)
+	return hmac.Equal(mac.Sum(nil), signatureBytes)
+}
+
+/**
+ * Creates a new replay cache. Returns nil if maxAge is
+ * zero, which disables signed requests.
+ *
+ * @param maxAge int Max seconds between the timestamp of
+ *   a request and the current time
+ * @return *ReplayCache Replay cache
+ */
+func NewReplayCache(maxAge int) *ReplayCache {
+	if maxAge <= 0 {
+		return nil
+	}
+
+	return &ReplayCache{
+		maxAge: time.Duration(maxAge) * time.Second,
+		nonces: make(map[string]time.Time),
+	}
+}
+
+/**
+ * Checks if the timestamp of a request is recent enough to
+ * be accepted.
+ *
+ * @param timestamp time.Time Timestamp of the request
+ * @return bool True if it is within the max age
+ */
+func (rc *ReplayCache) Fresh(timestamp time.Time) bool {
+	age := time.Since(timestamp)
+	return age <= rc.maxAge && age >= -rc.maxAge
+}
+
+/**
+ * Records the nonce of a request, unless it was already
+ * used with the same key. Nonces are kept until the
+ * timestamp of their request is no longer fresh.
+ *
+ * @param keyID string ID of the signing key
+ * @param nonce string Nonce of the request
+ * @param timestamp time.Time Timestamp of the request
+ * @return bool True if the nonce was used before
+ */
+func (rc *ReplayCache) Seen(keyID, nonce string, timestamp time.Time) bool {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	id := keyID + "." + nonce
+	if expiry, exists := rc.nonces[id]; exists && time.Now().Before(expiry) {
+		return true
+	}
+
+	rc.nonces[id] = timestamp.Add(rc.maxAge)
+	return false
+}
+
+/**
+ * Starts a cleanup routine to remove expired nonces. Run
+ * as a goroutine. Does nothing on a nil cache.
+ */
+func (rc *ReplayCache) StartCleanup() {
+	if rc == nil {
+		return
+	}
+
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			now := time.Now()
+			rc.mu.Lock()
+			for id, expiry := range rc.nonces {
+				if now.After(expiry) {
+					delete(rc.nonces, id)
+				}
+			}
+			rc.mu.Unlock()
+		}
+	}()
+}
@@ -0,0 +1,150 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package control
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+/**
+ * Signing secret of the alpha key in the signing key
+ * store.
+ */
+const testSigningSecret = "0123456789abcdef0123456789abcdef"
+
+/**
+ * Creates a key store with a key alpha that can sign
+ * requests and a key beta that can't.
+ *
+ * @param t *testing.T Test
+ * @return *KeyStore Key store
+ */
+func newSigningKeyStore(t *testing.T) *KeyStore {
+	t.Helper()
+
+	registry := Registry{Keys: []RegistryEntry{
+		{
+			ID:            "alpha",
+			Hash:          HashSecret("alpha-secret", "alpha-salt"),
+			Salt:          "alpha-salt",
+			SigningSecret: testSigningSecret,
+		},
+		{
+			ID:   "beta",
+			Hash: HashSecret("beta-secret", "beta-salt"),
+			Salt: "beta-salt",
+		},
+	}}
+	registryFile := filepath.Join(t.TempDir(), "keys.toml")
+	if err := registry.Write(registryFile); err != nil {
+		t.Fatal(err)
+	}
+
+	ks, err := InitializeKeystore("", registryFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ks
+}
+
+func TestSigningString(t *testing.T) {
+	got := string(SigningString("POST", "/run?x=1", "alpha", "1700000000", "nonce-nonce-nonce", []byte("body")))
+	bodyHash := sha256.Sum256([]byte("body"))
+	want := "POST\n/run?x=1\nalpha\n1700000000\nnonce-nonce-nonce\n" + hex.EncodeToString(bodyHash[:])
+	if got != want {
+		t.Errorf("SigningString = %q, want %q", got, want)
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	ks := newSigningKeyStore(t)
+
+	key := ks.SigningKey("alpha")
+	if key == nil {
+		t.Fatal("alpha can't sign")
+	}
+	if ks.SigningKey("beta") != nil || ks.SigningKey(MasterKeyID) != nil || ks.SigningKey("gamma") != nil {
+		t.Error("keys without a signing secret can sign")
+	}
+
+	message := SigningString("POST", "/run", "alpha", "1700000000", "nonce-nonce-nonce", []byte("{}"))
+	mac := hmac.New(sha256.New, []byte(testSigningSecret))
+	mac.Write(message)
+	signature := hex.EncodeToString(mac.Sum(nil))
+
+	if !key.VerifySignature(message, signature) {
+		t.Error("valid signature rejected")
+	}
+	if !key.VerifySignature(message, strings.ToUpper(signature)) {
+		t.Error("upper case signature rejected")
+	}
+
+	tampered := SigningString("POST", "/run", "alpha", "1700000000", "nonce-nonce-nonce", []byte("{ }"))
+	for name, check := range map[string]bool{
+		"tampered body": key.VerifySignature(tampered, signature),
+		"truncated":     key.VerifySignature(message, signature[:62]),
+		"not hex":       key.VerifySignature(message, "zz"+signature[2:]),
+		"empty":         key.VerifySignature(message, ""),
+	} {
+		if check {
+			t.Errorf("%s signature accepted", name)
+		}
+	}
+}
+
+func TestReplayCache(t *testing.T) {
+	if NewReplayCache(0) != nil {
+		t.Error("replay cache with a max age of 0 should be nil")
+	}
+
+	rc := NewReplayCache(300)
+	now := time.Now()
+
+	for _, test := range []struct {
+		age   time.Duration
+		fresh bool
+	}{
+		{0, true},
+		{299 * time.Second, true},
+		{-299 * time.Second, true},
+		{301 * time.Second, false},
+		{-301 * time.Second, false},
+	} {
+		if got := rc.Fresh(now.Add(-test.age)); got != test.fresh {
+			t.Errorf("Fresh(now - %s) = %t, want %t", test.age, got, test.fresh)
+		}
+	}
+
+	if rc.Seen("alpha", "nonce-nonce-nonce", now) {
+		t.Error("new nonce seen")
+	}
+	if !rc.Seen("alpha", "nonce-nonce-nonce", now) {
+		t.Error("replayed nonce not seen")
+	}
+	if rc.Seen("beta", "nonce-nonce-nonce", now) {
+		t.Error("nonce of another key seen")
+	}
+	if rc.Seen("alpha", "old-nonce-old-nonce", now.Add(-time.Hour)) || rc.Seen("alpha", "old-nonce-old-nonce", now) {
+		t.Error("expired nonce seen")
+	}
+}
@@ -17,20 +17,154 @@
 package control
 
 import (
+	"container/list"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"golang.org/x/time/rate"
 )
 
 /**
- * Struct that holds the cached master key.
+ * Struct that holds the keys clients are authorized with.
  *
- * @field cachedKey atomic.Value Cached master key
+ * @field keys map[string]*Key Keys by ID
+ * @field verified map[[32]byte]*list.Element Recently
+ *   verified user keys by their SHA-256 hash, pointing into
+ *   verifiedOrder
+ * @field verifiedOrder *list.List Verified user keys from
+ *   most to least recently used, holding *verifiedKey
+ * @field decoy *Key Key with a random hash that nothing
+ *   matches, checked in place of keys that don't exist
+ * @field mu sync.Mutex Mutex for the verified keys
  */
 type KeyStore struct {
-	cachedKey atomic.Value
+	keys          map[string]*Key
+	verified      map[[32]byte]*list.Element
+	verifiedOrder *list.List
+	decoy         *Key
+	mu            sync.Mutex
+}
+
+/**
+ * Struct for a user key that was verified recently.
+ *
+ * @field sum [32]byte SHA-256 hash of the user key
+ * @field key *Key Key it belongs to
+ */
+type verifiedKey struct {
+	sum [32]byte
+	key *Key
+}
+
+/**
+ * Struct for a key clients are authorized with, and what
+ * it may be used for.
+ *
+ * @field ID string Key ID, the part of the key before the dot
+ * @field Label string Description of who the key belongs to
+ * @field Languages []string Entries of the languages the key
+ *   may run, all of them if empty
+ * @field MaxTimeout int Ceiling for the timeout of executions
+ *   run with the key, zero for no ceiling of its own
+ * @field Enabled bool Whether the key may be used
+ * @field hash []byte Argon2id hash of the secret
+ * @field salt string Salt of the hash
+ * @field signingSecret []byte Shared secret for signed
+ *   requests, nil if the key can't sign
+ */
+type Key struct {
+	ID            string
+	Label         string
+	Languages     []string
+	MaxTimeout    int
+	Enabled       bool
+	hash          []byte
+	salt          string
+	signingSecret []byte
+}
+
+/**
+ * Struct for a key in the key registry file.
+ *
+ * @field ID string Key ID
+ * @field Hash string Hex encoded argon2id hash of the secret
+ * @field Salt string Salt of the hash
+ * @field Label string Description of who the key belongs to
+ * @field Languages []string Entries of the allowed languages
+ * @field MaxTimeout int Ceiling for the execution timeout
+ * @field Enabled *bool Whether the key may be used, true
+ *   if unset
+ * @field SigningSecret string Shared secret for signed
+ *   requests, empty if the key can't sign
+ */
+type RegistryEntry struct {
+	ID            string   `toml:"id"`
+	Hash          string   `toml:"hash"`
+	Salt          string   `toml:"salt"`
+	Label         string   `toml:"label,omitempty"`
+	Languages     []string `toml:"languages,omitempty"`
+	MaxTimeout    int      `toml:"max_timeout,omitzero"`
+	Enabled       *bool    `toml:"enabled"`
+	SigningSecret string   `toml:"signing_secret,omitempty"`
+}
+
+/**
+ * Struct for the key registry file.
+ *
+ * @field Keys []RegistryEntry Keys
+ */
+type Registry struct {
+	Keys []RegistryEntry `toml:"keys"`
+}
+
+/**
+ * Struct that tracks failed authentication attempts per
+ * client and bans clients with too many of them.
+ *
+ * @field clients map[string]*authClient Clients by address
+ * @field maxFailures int Failures that get a client banned
+ * @field window time.Duration Time failures are counted in
+ * @field banTime time.Duration Time a ban lasts
+ * @field mu sync.Mutex Mutex for the map
+ */
+type AuthGuard struct {
+	clients     map[string]*authClient
+	maxFailures int
+	window      time.Duration
+	banTime     time.Duration
+	mu          sync.Mutex
+}
+
+/**
+ * Struct that holds the failed attempts of a client.
+ *
+ * @field failures int Failures in the current window
+ * @field windowStart time.Time Time of the first failure
+ *   in the current window
+ * @field bannedUntil time.Time End of the ban, zero if the
+ *   client isn't banned
+ */
+type authClient struct {
+	failures    int
+	windowStart time.Time
+	bannedUntil time.Time
+}
+
+/**
+ * Struct that remembers the nonces of signed requests
+ * until their timestamps are too old to be accepted, so
+ * that a request can't be replayed.
+ *
+ * @field maxAge time.Duration Max difference between the
+ *   timestamp of a request and the current time
+ * @field nonces map[string]time.Time Expiry of each nonce,
+ *   by key ID and nonce
+ * @field mu sync.Mutex Mutex for the map
+ */
+type ReplayCache struct {
+	maxAge time.Duration
+	nonces map[string]time.Time
+	mu     sync.Mutex
 }
 
 /**
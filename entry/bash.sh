@@ -1 +1,2 @@
-bash $@ source.sh
+src=$1 && shift
+bash $@ $src
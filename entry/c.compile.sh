@@ -0,0 +1,2 @@
+src=$1 && shift
+gcc -I. $(find . -name '*.c') $@ -o /tmp/run
@@ -1,2 +1 @@
-gcc source.c $@ -o /tmp/run &&
-./tmp/run
+/tmp/run
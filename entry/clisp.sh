@@ -1 +1,2 @@
-sbcl --script $@ source.lisp
+src=$1 && shift
+sbcl --script $@ $src
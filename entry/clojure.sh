@@ -1 +1,2 @@
-clojure $@ source.clj
+src=$1 && shift
+clojure $@ $src
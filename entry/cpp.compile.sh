@@ -0,0 +1,2 @@
+src=$1 && shift
+g++ -I. $(find . -name '*.cpp') $@ -o /tmp/run
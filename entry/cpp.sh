@@ -1,2 +1 @@
-g++ source.cpp $@ -o /tmp/run &&
-./tmp/run
+/tmp/run
@@ -1 +1,2 @@
-GC_NPROCS=1 CRYSTAL_CACHE_DIR=/tmp/.crystal crystal run $@ source.cr
+src=$1 && shift
+GC_NPROCS=1 CRYSTAL_CACHE_DIR=/tmp/.crystal crystal run $@ $src
@@ -0,0 +1,2 @@
+src=$1 && shift
+csc $(find . -name '*.cs') /tmp/GlobalUsings.cs $@ -nologo -unsafe -out:/tmp/run
@@ -1,2 +1 @@
-csc source.cs /tmp/GlobalUsings.cs $@ -nologo -unsafe -out:/tmp/run &&
 mono /tmp/run
@@ -0,0 +1,2 @@
+src=$1 && shift
+gdc $(find . -name '*.d') $@ -o /tmp/run
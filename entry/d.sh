@@ -1,2 +1 @@
-gdc source.d $@ -o /tmp/run &&
-./tmp/run
+/tmp/run
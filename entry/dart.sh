@@ -1 +1,2 @@
-dart run $@ source.dart
+src=$1 && shift
+dart run $@ $src
@@ -0,0 +1,2 @@
+src=$1 && shift
+gfortran $src $@ -o /tmp/run
@@ -1,2 +1 @@
-gfortran source.f90 $@ -o /tmp/run &&
-./tmp/run
+/tmp/run
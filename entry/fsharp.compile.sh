@@ -0,0 +1,2 @@
+src=$1 && shift
+mono /usr/local/fsharp/fsc.exe $src --nologo --out:/tmp/run.exe
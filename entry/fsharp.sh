@@ -1,2 +1 @@
-mono /usr/local/fsharp/fsc.exe source.fs --nologo --out:/tmp/run.exe &&
 mono /tmp/run.exe
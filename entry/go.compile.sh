@@ -0,0 +1,2 @@
+src=$1 && shift
+gccgo $(find $(dirname $src) -maxdepth 1 -name '*.go' ! -name '*_test.go') $@ -o /tmp/run
@@ -1,2 +1 @@
-gccgo source.go $@ -o /tmp/run &&
-./tmp/run
+/tmp/run
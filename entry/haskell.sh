@@ -1 +1,2 @@
-runghc --ghc-arg="-v0" $@ source.hs
+src=$1 && shift
+runghc --ghc-arg="-v0" $@ $src
@@ -0,0 +1,2 @@
+src=$1 && shift
+javac -d /tmp/classes $(find . -name '*.java')
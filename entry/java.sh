@@ -1 +1,4 @@
-java $@ source.java
+src=$1 && shift
+pkg=$(sed -n 's/^[[:space:]]*package[[:space:]]\{1,\}\([A-Za-z0-9_.]*\)[[:space:]]*;.*/\1/p' $src | head -n 1)
+main=$(basename $src .java)
+java $@ -cp /tmp/classes ${pkg:+$pkg.}$main
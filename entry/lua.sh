@@ -1 +1,2 @@
-lua $@ source.lua
+src=$1 && shift
+lua $@ $src
@@ -0,0 +1,3 @@
+src=$1 && shift
+nasm -f elf64 $@ $src -o /tmp/run.o &&
+ld /tmp/run.o -o /tmp/run
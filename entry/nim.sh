@@ -1 +1,2 @@
-nim compile --nimcache:/tmp --usenimcache -w:off --hints:off --passC:"-w" $@ --run source.nim
+src=$1 && shift
+nim compile --nimcache:/tmp --usenimcache -w:off --hints:off --passC:"-w" $@ --run $src
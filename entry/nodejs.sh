@@ -1 +1,2 @@
-node $@ source.js
+src=$1 && shift
+node $@ $src
@@ -1 +1,2 @@
-perl $@ source.pl
+src=$1 && shift
+perl $@ $src
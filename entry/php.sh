@@ -1 +1,2 @@
-php $@ source.php
+src=$1 && shift
+php $@ $src
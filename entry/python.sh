@@ -1 +1,2 @@
-python3 $@ source.py
+src=$1 && shift
+python3 $@ $src
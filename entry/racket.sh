@@ -1 +1,2 @@
-racket $@ source.rkt
+src=$1 && shift
+racket $@ $src
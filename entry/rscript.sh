@@ -1 +1,2 @@
-Rscript $@ source.r
+src=$1 && shift
+Rscript $@ $src
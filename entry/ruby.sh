@@ -1 +1,2 @@
-ruby $@ source.rb
+src=$1 && shift
+ruby $@ $src
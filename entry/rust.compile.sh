@@ -0,0 +1,2 @@
+src=$1 && shift
+rustc $src $@ -o /tmp/run
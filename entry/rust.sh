@@ -1,2 +1 @@
-rustc source.rs $@ -o /tmp/run &&
-./tmp/run
+/tmp/run
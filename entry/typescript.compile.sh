@@ -0,0 +1,2 @@
+src=$1 && shift
+swc -q $src -o /tmp/run.js
@@ -1,2 +1,2 @@
-swc -q source.ts -o /tmp/run.js &&
+src=$1 && shift
 node $@ /tmp/run.js
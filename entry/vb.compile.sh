@@ -0,0 +1,2 @@
+src=$1 && shift
+vbc $(find . -name '*.vb') $@ -vbruntime* -nologo -out:/tmp/run.exe
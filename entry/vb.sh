@@ -1,2 +1 @@
-vbc source.vb $@ -vbruntime* -nologo -out:/tmp/run.exe &&
 mono /tmp/run.exe
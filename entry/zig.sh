@@ -1 +1,2 @@
-zig run --global-cache-dir /tmp $@ -femit-bin=/tmp/run.o source.zig
+src=$1 && shift
+zig run --global-cache-dir /tmp $@ -femit-bin=/tmp/run.o $src
@@ -0,0 +1,160 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package fake
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"whipcode/sandbox"
+)
+
+/**
+ * Creates a new fake executor. By default every execution
+ * succeeds instantly and echoes its stdin to stdout, so
+ * routes can be exercised without podman installed.
+ *
+ * @return *Executor New Executor instance
+ */
+func NewExecutor() *Executor {
+	return &Executor{}
+}
+
+/**
+ * Runs an execution in memory.
+ *
+ * @param ctx context.Context Context of the execution
+ * @param opt sandbox.ExecutionOptions Execution options
+ * @return sandbox.Result Result of the execution
+ */
+func (f *Executor) Run(ctx context.Context, opt sandbox.ExecutionOptions) sandbox.Result {
+	if f.Handler != nil {
+		return f.Handler(ctx, opt)
+	}
+
+	if len(opt.TestCases) > 0 {
+		return testResult(opt)
+	}
+
+	stdin := opt.Stdin
+	if opt.StdinReader != nil {
+		stdinBytes, _ := io.ReadAll(opt.StdinReader)
+		stdin = string(stdinBytes)
+	}
+
+	if ctx.Err() != nil {
+		return sandbox.Result{Status: sandbox.StatusCancelled, Body: map[string]interface{}{
+			"detail":    "execution cancelled",
+			"cancelled": true,
+		}}
+	}
+
+	if opt.OnOutput != nil && stdin != "" {
+		opt.OnOutput("run", "stdout", []byte(stdin))
+	}
+
+	return sandbox.Result{Status: http.StatusOK, Body: phase(stdin)}
+}
+
+/**
+ * Returns 0, fake executions are never rejected.
+ *
+ * @return int Seconds to wait
+ */
+func (f *Executor) RetryAfter() int {
+	return 0
+}
+
+/**
+ * Returns right away, fake executions finish instantly.
+ *
+ * @param ctx context.Context Deadline for the wait
+ * @return error Always nil
+ */
+func (f *Executor) Drain(ctx context.Context) error {
+	return nil
+}
+
+/**
+ * Does nothing, the fake executor holds no resources.
+ */
+func (f *Executor) Close() {}
+
+/**
+ * Builds the result of a successful phase that wrote the
+ * given output to stdout.
+ *
+ * @param stdout string Output of the phase
+ * @return map[string]interface{} Phase result
+ */
+func phase(stdout string) map[string]interface{} {
+	return map[string]interface{}{
+		"stdout":           stdout,
+		"stderr":           "",
+		"exit_code":        0,
+		"signal":           nil,
+		"oom_killed":       false,
+		"timeout":          false,
+		"container_age":    0.0,
+		"stdout_truncated": false,
+		"stderr_truncated": false,
+		"stdout_bytes":     int64(len(stdout)),
+		"stderr_bytes":     int64(0),
+		"queue_position":   0,
+		"queue_wait":       0.0,
+		"cached":           false,
+	}
+}
+
+/**
+ * Builds the result of a batch of test cases, where each
+ * case outputs its own stdin.
+ *
+ * @param opt sandbox.ExecutionOptions Execution options
+ * @return sandbox.Result Result of the execution
+ */
+func testResult(opt sandbox.ExecutionOptions) sandbox.Result {
+	cases := make([]map[string]interface{}, 0, len(opt.TestCases))
+	passed := 0
+
+	for _, testCase := range opt.TestCases {
+		result := phase(testCase.Stdin)
+		delete(result, "container_age")
+		delete(result, "queue_position")
+		delete(result, "queue_wait")
+		delete(result, "cached")
+		result["duration"] = 0.0
+
+		result["verdict"] = "wrong_answer"
+		if sandbox.CompareOutput(opt.CompareMode, opt.FloatTolerance, testCase.Stdin, testCase.ExpectedStdout) {
+			result["verdict"] = "passed"
+			passed++
+		}
+		cases = append(cases, result)
+	}
+
+	return sandbox.Result{Status: http.StatusOK, Body: map[string]interface{}{
+		"test_cases":     cases,
+		"passed":         passed,
+		"total":          len(cases),
+		"container_age":  0.0,
+		"queue_position": 0,
+		"queue_wait":     0.0,
+		"cached":         false,
+	}}
+}
@@ -0,0 +1,34 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package fake
+
+import (
+	"context"
+
+	"whipcode/sandbox"
+)
+
+/**
+ * Struct for an executor that never starts a sandbox.
+ *
+ * @field Handler func(context.Context, sandbox.ExecutionOptions) sandbox.Result
+ *   Produces the result of an execution, the default echo
+ *   behaviour is used if nil
+ */
+type Executor struct {
+	Handler func(context.Context, sandbox.ExecutionOptions) sandbox.Result
+}
@@ -0,0 +1,212 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+)
+
+var (
+	ErrTooManyJobs    = errors.New("too many running jobs")
+	ErrTooManyKeyJobs = errors.New("too many running jobs for this key")
+)
+
+/**
+ * Creates a new job store.
+ *
+ * @param ttl int Seconds finished jobs are kept
+ * @param maxRunning int Max jobs running at once
+ * @param maxPerOwner int Max jobs of a key running at once
+ * @return *Store Job store
+ */
+func NewStore(ttl, maxRunning, maxPerOwner int) *Store {
+	return &Store{
+		jobs:           make(map[string]*Job),
+		ttl:            time.Duration(ttl) * time.Second,
+		maxRunning:     maxRunning,
+		maxPerOwner:    maxPerOwner,
+		runningByOwner: make(map[string]int),
+	}
+}
+
+/**
+ * Starts the given function in the background and
+ * returns the ID of the new job. Jobs count as running
+ * until their function returns, even once they are
+ * cancelled.
+ *
+ * @param owner string ID of the key submitting the job
+ * @param run RunFunc Function that executes the job
+ * @return string Job ID
+ * @return error ErrTooManyKeyJobs or ErrTooManyJobs if
+ *   the key or the store has too many running jobs
+ */
+func (s *Store) Submit(owner string, run RunFunc) (string, error) {
+	idBytes := make([]byte, 16)
+	rand.Read(idBytes)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		id:      hex.EncodeToString(idBytes),
+		owner:   owner,
+		status:  "running",
+		created: time.Now(),
+		cancel:  cancel,
+	}
+
+	s.mu.Lock()
+	switch {
+	case s.runningByOwner[owner] >= s.maxPerOwner:
+		s.mu.Unlock()
+		cancel()
+		return "", ErrTooManyKeyJobs
+	case s.running >= s.maxRunning:
+		s.mu.Unlock()
+		cancel()
+		return "", ErrTooManyJobs
+	}
+	s.jobs[job.id] = job
+	s.running++
+	s.runningByOwner[owner]++
+	s.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		code, result := run(ctx)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.running--
+		if s.runningByOwner[owner]--; s.runningByOwner[owner] == 0 {
+			delete(s.runningByOwner, owner)
+		}
+		if job.status == "cancelled" {
+			return
+		}
+
+		job.status = "completed"
+		if code != http.StatusOK {
+			job.status = "failed"
+		}
+		job.code = code
+		job.result = result
+		job.finished = time.Now()
+	}()
+
+	return job.id, nil
+}
+
+/**
+ * Returns the public view of a job. Jobs of other keys
+ * are treated as if they don't exist.
+ *
+ * @param id string Job ID
+ * @param owner string ID of the key asking for the job
+ * @return map[string]interface{} Job status and result
+ * @return bool False if the job doesn't exist
+ */
+func (s *Store) Get(id, owner string) (map[string]interface{}, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	job, exists := s.jobs[id]
+	if !exists || job.owner != owner {
+		return nil, false
+	}
+
+	return job.view(), true
+}
+
+/**
+ * Cancels a running job, which kills its container. Jobs
+ * of other keys are treated as if they don't exist.
+ *
+ * @param id string Job ID
+ * @param owner string ID of the key cancelling the job
+ * @return map[string]interface{} Job status
+ * @return bool False if the job doesn't exist
+ * @return bool False if the job already finished
+ */
+func (s *Store) Cancel(id, owner string) (map[string]interface{}, bool, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, exists := s.jobs[id]
+	if !exists || job.owner != owner {
+		return nil, false, false
+	}
+
+	if job.status != "running" {
+		return job.view(), true, false
+	}
+
+	job.cancel()
+	job.status = "cancelled"
+	job.finished = time.Now()
+
+	return job.view(), true, true
+}
+
+/**
+ * Starts a cleanup routine to remove finished jobs once
+ * they are older than the TTL. Run as a goroutine.
+ */
+func (s *Store) StartCleanup() {
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			s.mu.Lock()
+			for id, job := range s.jobs {
+				if job.status != "running" && time.Since(job.finished) >= s.ttl {
+					delete(s.jobs, id)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}()
+}
+
+/**
+ * Builds the public view of a job. The caller must hold
+ * the store's lock.
+ *
+ * @return map[string]interface{} Job status and result
+ */
+func (job *Job) view() map[string]interface{} {
+	view := map[string]interface{}{
+		"id":          job.id,
+		"status":      job.status,
+		"created_at":  job.created.UTC().Format(time.RFC3339),
+		"finished_at": nil,
+		"status_code": nil,
+		"result":      job.result,
+	}
+
+	if !job.finished.IsZero() {
+		view["finished_at"] = job.finished.UTC().Format(time.RFC3339)
+	}
+	if job.code != 0 {
+		view["status_code"] = job.code
+	}
+
+	return view
+}
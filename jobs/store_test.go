@@ -0,0 +1,111 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package jobs
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+/**
+ * Returns a job function that runs until it is released
+ * or cancelled.
+ *
+ * @param release chan struct{} Closed to finish the job
+ * @return RunFunc Job function
+ */
+func blockingJob(release chan struct{}) RunFunc {
+	return func(ctx context.Context) (int, map[string]interface{}) {
+		select {
+		case <-release:
+		case <-ctx.Done():
+			<-release
+		}
+		return http.StatusOK, map[string]interface{}{}
+	}
+}
+
+/**
+ * Polls the number of running jobs until it reaches the
+ * given value, failing the test if it doesn't within a
+ * second.
+ *
+ * @param t *testing.T Test
+ * @param s *Store Job store
+ * @param want int Expected number of running jobs
+ */
+func waitRunning(t *testing.T, s *Store, want int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		s.mu.RLock()
+		running := s.running
+		s.mu.RUnlock()
+		if running == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d running jobs, want %d", running, want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSubmitLimits(t *testing.T) {
+	s := NewStore(600, 3, 2)
+	release := make(chan struct{})
+
+	ids := make([]string, 0, 3)
+	for _, owner := range []string{"alpha", "alpha", "beta"} {
+		id, err := s.Submit(owner, blockingJob(release))
+		if err != nil {
+			t.Fatalf("Submit(%s) = %v", owner, err)
+		}
+		ids = append(ids, id)
+	}
+
+	if _, err := s.Submit("alpha", blockingJob(release)); !errors.Is(err, ErrTooManyKeyJobs) {
+		t.Errorf("Submit past the key limit = %v, want %v", err, ErrTooManyKeyJobs)
+	}
+	if _, err := s.Submit("gamma", blockingJob(release)); !errors.Is(err, ErrTooManyJobs) {
+		t.Errorf("Submit past the global limit = %v, want %v", err, ErrTooManyJobs)
+	}
+
+	// Cancelled jobs hold their slot until they return
+	if _, _, cancelled := s.Cancel(ids[0], "alpha"); !cancelled {
+		t.Fatal("job not cancelled")
+	}
+	if _, err := s.Submit("gamma", blockingJob(release)); !errors.Is(err, ErrTooManyJobs) {
+		t.Errorf("Submit after a cancel = %v, want %v", err, ErrTooManyJobs)
+	}
+
+	close(release)
+	waitRunning(t, s, 0)
+
+	if len(s.runningByOwner) != 0 {
+		t.Errorf("running jobs left for %v", s.runningByOwner)
+	}
+	if _, err := s.Submit("alpha", blockingJob(release)); err != nil {
+		t.Errorf("Submit after the jobs finished = %v", err)
+	}
+	if job, _ := s.Get(ids[1], "alpha"); job["status"] != "completed" || job["status_code"] != http.StatusOK {
+		t.Errorf("job %v, want completed with 200", job)
+	}
+}
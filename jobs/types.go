@@ -0,0 +1,78 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package jobs
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+/**
+ * Struct for a submitted job.
+ *
+ * @field id string Job ID
+ * @field owner string ID of the key that submitted the job
+ * @field status string One of running, completed, failed
+ *   or cancelled
+ * @field code int HTTP status code of the result
+ * @field result map[string]interface{} Result of the
+ *   execution, nil until the job has finished
+ * @field created time.Time Time the job was submitted
+ * @field finished time.Time Time the job finished
+ * @field cancel context.CancelFunc Cancels the execution
+ */
+type Job struct {
+	id       string
+	owner    string
+	status   string
+	code     int
+	result   map[string]interface{}
+	created  time.Time
+	finished time.Time
+	cancel   context.CancelFunc
+}
+
+/**
+ * Struct that holds all jobs in memory. Finished jobs are
+ * evicted once they are older than the TTL.
+ *
+ * @field jobs map[string]*Job Map of jobs
+ * @field ttl time.Duration Time finished jobs are kept
+ * @field maxRunning int Max jobs running at once
+ * @field maxPerOwner int Max jobs of a key running at once
+ * @field running int Jobs whose execution hasn't returned
+ * @field runningByOwner map[string]int Running jobs of
+ *   each key
+ * @field mu sync.RWMutex Mutex for the map, the counters
+ *   and the jobs
+ */
+type Store struct {
+	jobs           map[string]*Job
+	ttl            time.Duration
+	maxRunning     int
+	maxPerOwner    int
+	running        int
+	runningByOwner map[string]int
+	mu             sync.RWMutex
+}
+
+/**
+ * Function that runs the execution of a job and returns
+ * its HTTP status code and response body.
+ */
+type RunFunc func(ctx context.Context) (int, map[string]interface{})
@@ -23,15 +23,24 @@
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
+	"sync/atomic"
 	"syscall"
 
 	"github.com/charmbracelet/log"
 
+	"whipcode/audit"
 	"whipcode/build"
+	"whipcode/bwrap"
+	"whipcode/cache"
 	"whipcode/config"
 	"whipcode/control"
+	"whipcode/fake"
+	"whipcode/jobs"
+	"whipcode/metrics"
 	"whipcode/podman"
 	"whipcode/routes"
+	"whipcode/sandbox"
 	"whipcode/server"
 	"whipcode/utils"
 )
@@ -48,17 +57,26 @@ func main() {
 	})
 	log.SetDefault(logger)
 
-	fileConfig := config.LoadConfig("config.toml")
+	fileConfig, err := config.LoadConfig("config.toml")
+	if err != nil {
+		log.Fatal("Could not load config", "File", "config.toml", "Error", err)
+	}
 
-	var version, enableTLS, enableCache, enablePing, standalone, genKey, selfTest, buildImages bool
-	var keyFile, proxy, podmanPath, tlsDir, langMap, addr string
-	var port, maxBytesSize, rlBurst, rlRefill, timeout int
+	if len(os.Args) > 1 && os.Args[1] == "keys" {
+		utils.Keys(os.Args[2:], fileConfig.Keys)
+		return
+	}
+
+	var version, enableTLS, enableCache, enablePing, enableJobs, enableSessions, enableMetrics, standalone, genKey, selfTest, buildImages bool
+	var keyFile, keysFile, proxy, backend, podmanPath, tlsDir, langMap, addr, metricsAddr, logFormat string
+	var port, maxBytesSize, rlBurst, rlRefill, timeout, compileTimeout int
 
 	flag.Usage = func() {
 		fmt.Printf("usage: %s [options]\n", os.Args[0])
 		fmt.Println(`
 commands:
     --gen-key                 generate a master key
+    keys <command>            manage the key registry, see keys --help
     --self-test               run self test
     --build-images            build images`)
 		fmt.Println(`
@@ -69,14 +87,22 @@ func main() {
     -p, --port       PORT     port to listen on
     -b, --max-bytes  BYTES    max bytes to accept
     -t, --timeout    SECONDS  timeout for executions
+    --compile-timeout SECONDS timeout for compilation
     -k, --key        FILE     master key file
+    --keys           FILE     key registry file
     -m, --lang-map   FILE     language map file
+    --backend        NAME     sandbox backend (podman, bwrap, fake)
     --podman-path    PATH     path to podman
     --proxy          ADDR     reverse proxy address
+    --log-format     FORMAT   log format (text, json)
     --cache                   enable execution cache
     --tls                     enable tls
     --tls-dir        DIR      directory with cert and key
     --ping                    enable /ping endpoint
+    --jobs                    enable /jobs endpoints
+    --sessions                enable /session endpoint
+    --metrics                 enable /metrics endpoint
+    --metrics-addr   ADDR     serve /metrics on ADDR instead
     --standalone              enable rate limiting (CHECK README)
     --burst          COUNT    rate limit burst
     --refill	     SECONDS  rate limit refill time`)
@@ -95,21 +121,37 @@ func main() {
 	flag.IntVar(&maxBytesSize, "b", fileConfig.MaxBytes, "")
 	flag.IntVar(&timeout, "timeout", fileConfig.Timeout, "")
 	flag.IntVar(&timeout, "t", fileConfig.Timeout, "")
+	flag.IntVar(&compileTimeout, "compile-timeout", fileConfig.CompileTimeout, "")
 	flag.StringVar(&keyFile, "key", fileConfig.Key, "")
 	flag.StringVar(&keyFile, "k", fileConfig.Key, "")
+	flag.StringVar(&keysFile, "keys", fileConfig.Keys, "")
 	flag.StringVar(&langMap, "lang-map", fileConfig.LangMap, "")
 	flag.StringVar(&langMap, "m", fileConfig.LangMap, "")
+	flag.StringVar(&backend, "backend", fileConfig.Backend, "")
 	flag.StringVar(&podmanPath, "podman-path", fileConfig.PodmanPath, "")
 	flag.StringVar(&proxy, "proxy", fileConfig.Proxy, "")
+	flag.StringVar(&logFormat, "log-format", fileConfig.LogFormat, "")
 	flag.BoolVar(&enableCache, "cache", fileConfig.Cache, "")
 	flag.BoolVar(&enableTLS, "tls", fileConfig.TLS, "")
 	flag.StringVar(&tlsDir, "tls-dir", fileConfig.TLSDir, "")
 	flag.BoolVar(&enablePing, "ping", fileConfig.Ping, "")
+	flag.BoolVar(&enableJobs, "jobs", fileConfig.Jobs, "")
+	flag.BoolVar(&enableSessions, "sessions", fileConfig.Sessions, "")
+	flag.BoolVar(&enableMetrics, "metrics", fileConfig.Metrics, "")
+	flag.StringVar(&metricsAddr, "metrics-addr", fileConfig.MetricsAddr, "")
 	flag.BoolVar(&standalone, "standalone", fileConfig.Standalone, "")
 	flag.IntVar(&rlBurst, "burst", fileConfig.Burst, "")
 	flag.IntVar(&rlRefill, "refill", fileConfig.Refill, "")
 	flag.Parse()
 
+	switch logFormat {
+	case "json":
+		logger.SetFormatter(log.JSONFormatter)
+	case "text", "":
+	default:
+		log.Fatal("Unknown log format", "Format", logFormat)
+	}
+
 	switch {
 	case version:
 		fmt.Println(VERSION)
@@ -128,59 +170,321 @@ func main() {
 		return
 	}
 
-	if _, err := os.Stat(podmanPath); os.IsNotExist(err) {
-		log.Fatal("Podman binary not found", "Error", err)
-	}
-
 	if err := os.MkdirAll(filepath.Join(".", "run"), 0755); err != nil {
 		log.Fatal("Could not create temp dir", "Error", err)
 	}
 
+	keyStore, err := control.InitializeKeystore(keyFile, keysFile)
+	if err != nil {
+		log.Fatal("Could not load keys", "Error", err)
+	}
+
+	authGuard := control.NewAuthGuard(fileConfig.AuthMaxFailures, fileConfig.AuthFailureWindow, fileConfig.AuthBanTime)
+	authGuard.StartCleanup()
+
+	replayCache := control.NewReplayCache(fileConfig.SignatureMaxAge)
+	replayCache.StartCleanup()
+
+	var auditLog *audit.Logger
+	if fileConfig.AuditLog != "" {
+		if auditLog, err = audit.Open(fileConfig.AuditLog); err != nil {
+			log.Fatal("Could not open audit log", "File", fileConfig.AuditLog, "Error", err)
+		}
+		defer auditLog.Close()
+	}
+
+	jobStore := jobs.NewStore(fileConfig.JobTTL, fileConfig.MaxJobs, fileConfig.MaxJobsPerKey)
+	if enableJobs {
+		if fileConfig.MaxJobs <= 0 || fileConfig.MaxJobsPerKey <= 0 {
+			log.Fatal("Invalid value for maxJobs or maxJobsPerKey, set them in config.toml", "MaxJobs", fileConfig.MaxJobs, "MaxJobsPerKey", fileConfig.MaxJobsPerKey)
+		}
+		jobStore.StartCleanup()
+	}
+
+	langs, err := config.LoadLangs(langMap)
+	if err != nil {
+		log.Fatal("Could not load language map", "File", langMap, "Error", err)
+	}
+
+	maxTimeout := timeout
+	pools := []podman.PoolSpec{}
+	for _, lang := range langs {
+		maxTimeout = max(maxTimeout, lang.MaxTimeout)
+		if lang.PoolSize > 0 {
+			pools = append(pools, podman.PoolSpec{
+				Entry:   lang.Entry,
+				Run:     lang.Run,
+				Compile: lang.Compile,
+				Limits:  lang.Limits,
+				Size:    lang.PoolSize,
+			})
+		}
+	}
+
+	if fileConfig.BatchTimeout <= 0 {
+		log.Fatal("Invalid value for batchTimeout, set it in config.toml", "Value", fileConfig.BatchTimeout)
+	}
+
+	if compileTimeout <= 0 {
+		log.Fatal("Invalid value for compileTimeout, set it in config.toml or with --compile-timeout", "Value", compileTimeout)
+	}
+
+	ceilings, err := fileConfig.Ceilings()
+	if err != nil {
+		log.Fatal("Invalid resource ceilings, set them in config.toml", "Error", err)
+	}
+
+	if fileConfig.QueueTimeout <= 0 {
+		log.Fatal("Invalid value for queueTimeout, set it in config.toml", "Value", fileConfig.QueueTimeout)
+	}
+
+	if fileConfig.MaxQueued < 0 {
+		log.Fatal("Invalid value for maxQueued, set it in config.toml", "Value", fileConfig.MaxQueued)
+	}
+
+	queue := sandbox.NewQueue(fileConfig.MaxConcurrent, fileConfig.MaxQueued, fileConfig.QueueTimeout)
+
+	var store cache.Store
+	if enableCache {
+		switch fileConfig.CacheStore {
+		case "memory", "":
+			store = cache.NewMemoryStore(fileConfig.CacheMaxEntries, fileConfig.CacheTTL)
+
+		case "disk":
+			cacheMaxBytes, err := sandbox.ParseSize(fileConfig.CacheMaxBytes)
+			if err != nil {
+				log.Fatal("Invalid value for cacheMaxBytes", "Error", err)
+			}
+			diskStore, err := cache.NewDiskStore(fileConfig.CacheDir, cacheMaxBytes, fileConfig.CacheTTL)
+			if err != nil {
+				log.Fatal("Could not open cache", "Dir", fileConfig.CacheDir, "Error", err)
+			}
+			diskStore.StartCleanup()
+			store = diskStore
+
+		default:
+			log.Fatal("Unknown cache store", "Store", fileConfig.CacheStore)
+		}
+	}
+
+	var executor sandbox.Executor
+	switch backend {
+	case "podman", "":
+		if _, err := os.Stat(podmanPath); os.IsNotExist(err) {
+			log.Fatal("Podman binary not found", "Error", err)
+		}
+		for i := range pools {
+			pools[i].Lifetime = max(maxTimeout, fileConfig.BatchTimeout) + 1
+			if pools[i].Compile != "" {
+				pools[i].Lifetime += compileTimeout
+			}
+		}
+		runtime := podman.NewRuntime(podmanPath, pools)
+		metrics.RegisterPool(runtime.PoolStats)
+		executor = sandbox.NewEngine(runtime, timeout, compileTimeout, fileConfig.BatchTimeout, fileConfig.KillOnOutputLimit, queue, store)
+
+	case "bwrap":
+		if _, err := os.Stat(fileConfig.BwrapPath); os.IsNotExist(err) {
+			log.Fatal("Bwrap binary not found", "Error", err)
+		}
+		if _, err := os.Stat(fileConfig.SystemctlPath); os.IsNotExist(err) {
+			log.Fatal("Systemctl binary not found", "Error", err)
+		}
+		if len(pools) > 0 {
+			log.Warn("Warm pools are not supported by the bwrap backend, ignoring")
+		}
+		runtime, err := bwrap.NewRuntime(fileConfig.BwrapPath, fileConfig.SystemdRunPath, fileConfig.SystemctlPath, fileConfig.RootfsDir, fileConfig.BwrapTmpDir)
+		if err != nil {
+			log.Fatal("Could not set up the bwrap backend", "Error", err)
+		}
+		executor = sandbox.NewEngine(runtime, timeout, compileTimeout, fileConfig.BatchTimeout, fileConfig.KillOnOutputLimit, queue, store)
+
+	case "fake":
+		log.Warn("Using the fake backend, code will not be executed")
+		executor = fake.NewExecutor()
+
+	default:
+		log.Fatal("Unknown sandbox backend", "Backend", backend)
+	}
+
+	metrics.RegisterQueue(queue.Stats)
+	if store != nil {
+		metrics.RegisterCache(store.Stats)
+	}
+
 	exitChan := make(chan os.Signal, 1)
 	signal.Notify(exitChan, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-exitChan
-		podman.Cleanup()
-		os.Exit(0)
-	}()
 
-	keyStore, keyAndSalt := control.InitializeKeystore(keyFile)
+	var scopedParams atomic.Pointer[server.ScopedMiddlewareParams]
+	scopedParams.Store(&server.ScopedMiddlewareParams{
+		LangMap:        langs,
+		EnableCache:    enableCache,
+		KeyStore:       keyStore,
+		AuthGuard:      authGuard,
+		ReplayCache:    replayCache,
+		MaxBytesSize:   maxBytesSize,
+		MaxTestCases:   fileConfig.MaxTestCases,
+		Ceilings:       ceilings,
+		Executor:       executor,
+		JobStore:       jobStore,
+		IdleTimeout:    fileConfig.IdleTimeout,
+		SessionOrigins: fileConfig.SessionOrigins,
+		AuditLog:       auditLog,
+	})
 
-	scopedParams := server.ScopedMiddlewareParams{
-		LangMap:      *config.LoadLangs(langMap),
-		EnableCache:  enableCache,
-		KeyAndSalt:   keyAndSalt,
-		KeyStore:     keyStore,
-		MaxBytesSize: maxBytesSize,
-		Executor:     *podman.NewExecutor(timeout, podmanPath),
-	}
+	reloadChan := make(chan os.Signal, 1)
+	signal.Notify(reloadChan, syscall.SIGHUP)
+	go func() {
+		for range reloadChan {
+			if err := reload(&scopedParams, maxTimeout); err != nil {
+				log.Error("Reload failed, keeping the current state", "Error", err)
+				continue
+			}
+			log.Info("Reloaded configuration")
+		}
+	}()
 
 	http.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
 		server.Send(w, http.StatusNotFound, []byte(`{"detail": "not found"}`))
 	})
 
-	http.HandleFunc("POST /run", server.ScopedMiddleware(routes.Run, scopedParams))
+	http.HandleFunc("POST /run", server.ScopedMiddleware(routes.Run, &scopedParams))
 	http.HandleFunc("/run", func(w http.ResponseWriter, _ *http.Request) {
 		server.Send(w, http.StatusMethodNotAllowed, []byte(`{"detail": "method not allowed"}`))
 	})
 
+	http.HandleFunc("POST /run/stream", server.ScopedMiddleware(routes.Stream, &scopedParams))
+	http.HandleFunc("/run/stream", func(w http.ResponseWriter, _ *http.Request) {
+		server.Send(w, http.StatusMethodNotAllowed, []byte(`{"detail": "method not allowed"}`))
+	})
+
+	if enableJobs {
+		http.HandleFunc("POST /jobs", server.ScopedMiddleware(routes.SubmitJob, &scopedParams))
+		http.HandleFunc("GET /jobs/{id}", server.ScopedMiddleware(routes.GetJob, &scopedParams))
+		http.HandleFunc("DELETE /jobs/{id}", server.ScopedMiddleware(routes.CancelJob, &scopedParams))
+		http.HandleFunc("/jobs", func(w http.ResponseWriter, _ *http.Request) {
+			server.Send(w, http.StatusMethodNotAllowed, []byte(`{"detail": "method not allowed"}`))
+		})
+		http.HandleFunc("/jobs/{id}", func(w http.ResponseWriter, _ *http.Request) {
+			server.Send(w, http.StatusMethodNotAllowed, []byte(`{"detail": "method not allowed"}`))
+		})
+	}
+
+	if enableSessions {
+		http.HandleFunc("GET /session", server.ScopedMiddleware(routes.Session, &scopedParams))
+		http.HandleFunc("/session", func(w http.ResponseWriter, _ *http.Request) {
+			server.Send(w, http.StatusMethodNotAllowed, []byte(`{"detail": "method not allowed"}`))
+		})
+	}
+
 	if enablePing {
 		http.HandleFunc("/ping", routes.Ping)
 	}
 
+	if enableMetrics {
+		if metricsAddr != "" {
+			server.StartMetricsServer(metricsAddr)
+		} else {
+			http.Handle("GET /metrics", metrics.Handler())
+		}
+	}
+
 	rateLimiter := control.NewRateLimiter()
 	if standalone {
 		rateLimiter.StartCleanup()
 	}
 
+	var draining atomic.Bool
+
 	params := server.MiddlewareParams{
 		RateLimiter: rateLimiter,
 		Standalone:  standalone,
 		RlBurst:     rlBurst,
 		RlRefill:    rlRefill,
 		Proxy:       proxy,
+		Draining:    &draining,
 	}
 
 	handler := server.Middleware(http.DefaultServeMux, params)
-	server.StartServer(port, addr, handler, enableTLS, tlsDir, timeout)
+	srv := server.StartServer(port, addr, handler, enableTLS, tlsDir, fileConfig.QueueTimeout+max(maxTimeout, fileConfig.BatchTimeout)+compileTimeout)
+
+	<-exitChan
+	server.Shutdown(srv, &draining, executor, fileConfig.DrainTimeout)
+}
+
+/**
+ * Reloads the configuration file, the language map and the
+ * keys, and swaps them into the parameters of the handlers
+ * at once. Everything is loaded and validated first, so
+ * the current parameters are kept if anything fails.
+ * Settings given as flags keep their values, and settings
+ * that aren't part of the handler parameters need a restart.
+ *
+ * @param state *atomic.Pointer[server.ScopedMiddlewareParams]
+ *   Current parameters of the handlers
+ * @param maxTimeout int Highest execution timeout the
+ *   server was started with, which the write timeout of the
+ *   server is based on
+ * @return error Error object
+ */
+func reload(state *atomic.Pointer[server.ScopedMiddlewareParams], maxTimeout int) error {
+	fileConfig, err := config.LoadConfig("config.toml")
+	if err != nil {
+		return fmt.Errorf("config.toml: %w", err)
+	}
+
+	flags := make(map[string]string)
+	flag.Visit(func(f *flag.Flag) {
+		flags[f.Name] = f.Value.String()
+	})
+	override := func(value *string, names ...string) {
+		for _, name := range names {
+			if flagValue, set := flags[name]; set {
+				*value = flagValue
+			}
+		}
+	}
+
+	maxBytes := strconv.Itoa(fileConfig.MaxBytes)
+	override(&maxBytes, "max-bytes", "b")
+	override(&fileConfig.Key, "key", "k")
+	override(&fileConfig.Keys, "keys")
+	override(&fileConfig.LangMap, "lang-map", "m")
+
+	maxBytesSize, err := strconv.Atoi(maxBytes)
+	if err != nil || maxBytesSize <= 0 {
+		return fmt.Errorf("invalid value for maxBytes: %s", maxBytes)
+	}
+
+	ceilings, err := fileConfig.Ceilings()
+	if err != nil {
+		return err
+	}
+
+	langs, err := config.LoadLangs(fileConfig.LangMap)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fileConfig.LangMap, err)
+	}
+	for id, lang := range langs {
+		if lang.MaxTimeout > maxTimeout {
+			return fmt.Errorf("%s: language %s: timeout is above %d seconds, restart to raise it", fileConfig.LangMap, id, maxTimeout)
+		}
+	}
+
+	keyStore, err := control.InitializeKeystore(fileConfig.Key, fileConfig.Keys)
+	if err != nil {
+		return err
+	}
+
+	next := *state.Load()
+	next.LangMap = langs
+	next.KeyStore = keyStore
+	next.MaxBytesSize = maxBytesSize
+	next.MaxTestCases = fileConfig.MaxTestCases
+	next.Ceilings = ceilings
+	next.IdleTimeout = fileConfig.IdleTimeout
+	next.SessionOrigins = fileConfig.SessionOrigins
+	state.Store(&next)
+
+	return nil
 }
@@ -0,0 +1,189 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+/**
+ * Registry all metrics are registered with, kept apart
+ * from the global one so that only whipcode's own metrics
+ * and the process and Go runtime collectors are exported.
+ */
+var registry = prometheus.NewRegistry()
+
+/**
+ * Metrics updated throughout whipcode.
+ */
+var (
+	Requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "whipcode_http_requests_total",
+		Help: "HTTP requests by route and status code.",
+	}, []string{"route", "status"})
+
+	RateLimited = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "whipcode_rate_limited_total",
+		Help: "Requests rejected by the rate limiter.",
+	})
+
+	AuthFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "whipcode_auth_failures_total",
+		Help: "Requests with a key that was rejected, by reason.",
+	}, []string{"reason"})
+
+	AuthBans = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "whipcode_auth_bans_total",
+		Help: "Clients banned for too many failed authentication attempts.",
+	})
+
+	AuthBanned = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "whipcode_auth_banned_requests_total",
+		Help: "Requests rejected because the client is banned.",
+	})
+
+	ExecutionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "whipcode_execution_duration_seconds",
+		Help:    "Time from starting the sandbox to the end of the last phase, by language.",
+		Buckets: []float64{0.1, 0.25, 0.5, 1, 2, 5, 10, 20, 30, 60},
+	}, []string{"language"})
+
+	Timeouts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "whipcode_timeouts_total",
+		Help: "Executions with a phase that ran out of time, by language.",
+	}, []string{"language"})
+
+	OOMKills = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "whipcode_oom_kills_total",
+		Help: "Executions with a phase that was OOM killed, by language.",
+	}, []string{"language"})
+
+	Containers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "whipcode_containers_running",
+		Help: "Sandboxes currently running an execution.",
+	})
+
+	PodmanFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "whipcode_podman_failures_total",
+		Help: "Podman commands that failed, by command.",
+	}, []string{"command"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		Requests,
+		RateLimited,
+		AuthFailures,
+		AuthBans,
+		AuthBanned,
+		ExecutionDuration,
+		Timeouts,
+		OOMKills,
+		Containers,
+		PodmanFailures,
+	)
+}
+
+/**
+ * Returns the handler for the /metrics endpoint.
+ *
+ * @return http.Handler Handler
+ */
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+/**
+ * Exports the hit and miss counts of the execution cache.
+ *
+ * @param stats func() (int64, int64) Returns the hits and
+ *   misses
+ */
+func RegisterCache(stats func() (int64, int64)) {
+	registerHitsMisses("whipcode_cache", "execution cache", stats)
+}
+
+/**
+ * Exports the hit and miss counts of the warm container
+ * pool.
+ *
+ * @param stats func() (int64, int64) Returns the hits and
+ *   misses
+ */
+func RegisterPool(stats func() (int64, int64)) {
+	registerHitsMisses("whipcode_pool", "warm container pool", stats)
+}
+
+/**
+ * Exports the number of executions running in and waiting
+ * for a slot of the admission queue.
+ *
+ * @param stats func() (int, int) Returns the running and
+ *   waiting executions
+ */
+func RegisterQueue(stats func() (int, int)) {
+	registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "whipcode_queue_running",
+			Help: "Executions holding a slot of the admission queue.",
+		}, func() float64 {
+			running, _ := stats()
+			return float64(running)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "whipcode_queue_waiting",
+			Help: "Executions waiting for a slot of the admission queue.",
+		}, func() float64 {
+			_, waiting := stats()
+			return float64(waiting)
+		}),
+	)
+}
+
+/**
+ * Registers a pair of hit and miss counters read from the
+ * given function.
+ *
+ * @param prefix string Metric name prefix
+ * @param what string What the counters are for
+ * @param stats func() (int64, int64) Returns the hits and
+ *   misses
+ */
+func registerHitsMisses(prefix, what string, stats func() (int64, int64)) {
+	registry.MustRegister(
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: prefix + "_hits_total",
+			Help: "Hits of the " + what + ".",
+		}, func() float64 {
+			hits, _ := stats()
+			return float64(hits)
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: prefix + "_misses_total",
+			Help: "Misses of the " + what + ".",
+		}, func() float64 {
+			_, misses := stats()
+			return float64(misses)
+		}),
+	)
+}
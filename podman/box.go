@@ -0,0 +1,277 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package podman
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"whipcode/metrics"
+	"whipcode/sandbox"
+
+	"github.com/charmbracelet/log"
+)
+
+/**
+ * Starts a detached sandbox container that only sleeps,
+ * every phase is started inside it with podman exec. The
+ * container is killed by podman once its lifetime is
+ * over. Resource limits are taken from the execution
+ * options. The project directory ./run/run<boxID> has to
+ * exist, it is mounted read-only as the working directory.
+ *
+ * @param boxID string ID of the container, the request ID
+ *   for containers started for a request
+ * @param opt sandbox.ExecutionOptions Execution options
+ * @param lifetime int Maximum lifetime in seconds
+ * @param extra ...string Additional podman run flags
+ * @return *box Started container
+ * @return error Error object
+ */
+func (rt *Runtime) createBox(boxID string, opt sandbox.ExecutionOptions, lifetime int, extra ...string) (*box, error) {
+	b := &box{
+		podmanPath: rt.podmanPath,
+		name:       "whipcode-" + boxID,
+		started:    time.Now(),
+		projectDir: filepath.Join(".", "run", "run"+boxID),
+	}
+
+	args := []string{
+		"run",
+		"--detach",
+		"--name", b.name,
+		"--read-only",
+		"--no-hosts",
+		"--hostname", "box" + boxID,
+		"--network", "none",
+		"--timeout", strconv.Itoa(lifetime),
+		"--cap-drop", "ALL",
+		"--memory", strconv.FormatInt(opt.Limits.Memory, 10),
+		"--memory-reservation", strconv.FormatInt(min(opt.Limits.Memory, 128<<20), 10),
+		"--cpus", strconv.FormatFloat(opt.Limits.CPUs, 'f', -1, 64),
+		"--pids-limit", strconv.Itoa(opt.Limits.Pids),
+		"--user", "nobody",
+		"--tmpfs", fmt.Sprintf("/tmp:rw,size=%d,mode=1777", opt.Limits.Tmpfs),
+		"--tmpfs", "/var/tmp:ro,size=32m,mode=1777",
+		"--security-opt", "no-new-privileges",
+		"--security-opt", "mask=/run:/sys:/var",
+		"--security-opt", "label=type:whipcode.process",
+		"--security-opt", "proc-opts=hidepid=2,subset=pid",
+		"--unsetenv", "container",
+		"--label", instanceLabel + "=" + rt.instance,
+		"--volume", fmt.Sprintf("./entry/%s.sh:/entry.sh:z,ro", opt.Run),
+		"--volume", fmt.Sprintf("./run/run%s:/project:Z,ro", boxID),
+		"--workdir", "/project",
+	}
+	if opt.Compile != "" {
+		args = append(args, "--volume", fmt.Sprintf("./entry/%s.sh:/compile.sh:z,ro", opt.Compile))
+	}
+	if opt.RequestID != "" {
+		args = append(args, "--label", requestLabel+"="+opt.RequestID)
+	}
+	args = append(args, extra...)
+	args = append(args, "whipcode-"+opt.Entry, "sleep", strconv.Itoa(lifetime))
+
+	if out, err := exec.Command(rt.podmanPath, args...).CombinedOutput(); err != nil {
+		metrics.PodmanFailures.WithLabelValues("run").Inc()
+		return b, fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	out, err := exec.Command(rt.podmanPath, "inspect", "--format", "{{.State.CgroupPath}}", b.name).Output()
+	if err != nil {
+		metrics.PodmanFailures.WithLabelValues("inspect").Inc()
+		log.Debug("Could not inspect container", "Name", b.name, "Error", err)
+	}
+	b.cgroup = strings.TrimSpace(string(out))
+
+	return b, nil
+}
+
+/**
+ * Prepares a container for the given execution. The files
+ * are written into a project directory that is mounted
+ * read-only as the working directory of the container. A
+ * warm container from the pool is used if one is
+ * available, with the files written into its empty
+ * project directory. Otherwise a new container is started.
+ *
+ * @param opt sandbox.ExecutionOptions Execution options
+ * @param lifetime int Maximum lifetime in seconds
+ * @return sandbox.Box Prepared container
+ * @return error Error object
+ */
+func (rt *Runtime) Start(opt sandbox.ExecutionOptions, lifetime int) (sandbox.Box, error) {
+	if b := rt.take(opt, lifetime); b != nil {
+		err := b.inject(opt.Files)
+		if err == nil {
+			b.env = opt.Env
+			return b, nil
+		}
+		log.Warn("Could not use pooled container", "RequestID", opt.RequestID, "Name", b.name, "Error", err)
+		go b.Remove()
+	}
+
+	boxID := sandbox.BoxID(opt.RequestID)
+	projectDir := filepath.Join(".", "run", "run"+boxID)
+
+	if err := sandbox.WriteProject(projectDir, opt.Files); err != nil {
+		os.RemoveAll(projectDir)
+		return nil, fmt.Errorf("could not write to temp dir: %w", err)
+	}
+
+	var extra []string
+	for k, v := range opt.Env {
+		extra = append(extra, "--env", k+"="+v)
+	}
+
+	b, err := rt.createBox(boxID, opt, lifetime, extra...)
+	if err != nil {
+		b.Remove()
+		return nil, err
+	}
+	return b, nil
+}
+
+/**
+ * Returns the ID of the language's image. IDs are looked
+ * up again after a minute, so rebuilt images are noticed
+ * without a restart.
+ *
+ * @param entry string Entry point, also names the image
+ * @return string Image ID, empty if the lookup failed
+ */
+func (rt *Runtime) ImageID(entry string) string {
+	if cached, ok := rt.images.Load(entry); ok && time.Since(cached.(imageID).fetched) < time.Minute {
+		return cached.(imageID).id
+	}
+
+	out, err := exec.Command(rt.podmanPath, "image", "inspect", "--format", "{{.Id}}", "whipcode-"+entry).Output()
+	if err != nil {
+		metrics.PodmanFailures.WithLabelValues("image").Inc()
+		log.Debug("Could not look up image", "Entry", entry, "Error", err)
+		return ""
+	}
+
+	id := strings.TrimSpace(string(out))
+	rt.images.Store(entry, imageID{id: id, fetched: time.Now()})
+	return id
+}
+
+/**
+ * Writes the submitted files into the project directory
+ * of a pooled container, which is empty until then.
+ *
+ * @param files []sandbox.SourceFile Files to write
+ * @return error Error object
+ */
+func (b *box) inject(files []sandbox.SourceFile) error {
+	return sandbox.WriteFiles(b.projectDir, files)
+}
+
+/**
+ * Checks if the container would be killed by podman before
+ * an execution with the given lifetime is over.
+ *
+ * @param lifetime int Lifetime of the execution in seconds
+ * @return bool True if there isn't enough lifetime left
+ */
+func (b *box) expires(lifetime int) bool {
+	return time.Since(b.started)+time.Duration(lifetime)*time.Second > poolLifetime*time.Second
+}
+
+/**
+ * Reads the number of processes killed by the OOM killer
+ * from the container's cgroup. Returns 0 if the cgroup
+ * can't be read.
+ *
+ * @return int Number of OOM kills
+ */
+func (b *box) OOMKills() int {
+	if b.cgroup == "" {
+		return 0
+	}
+
+	events, err := os.ReadFile(filepath.Join("/sys/fs/cgroup", b.cgroup, "memory.events"))
+	if err != nil {
+		log.Debug("Could not read memory events", "Name", b.name, "Error", err)
+		return 0
+	}
+
+	for _, line := range strings.Split(string(events), "\n") {
+		if count, found := strings.CutPrefix(line, "oom_kill "); found {
+			n, _ := strconv.Atoi(count)
+			return n
+		}
+	}
+
+	return 0
+}
+
+/**
+ * Builds the podman exec command that runs a shell script
+ * inside the container, in its working directory and with
+ * the environment of the execution.
+ *
+ * @param ctx context.Context Context of the phase
+ * @param interactive bool Whether stdin is attached
+ * @param script string Shell script to run
+ * @return *exec.Cmd Command object
+ */
+func (b *box) Command(ctx context.Context, interactive bool, script string) *exec.Cmd {
+	args := []string{"exec"}
+	if interactive {
+		args = append(args, "--interactive")
+	}
+	for k, v := range b.env {
+		args = append(args, "--env", k+"="+v)
+	}
+	args = append(args, b.name, "sh", "-c", script)
+
+	return exec.CommandContext(ctx, b.podmanPath, args...)
+}
+
+/**
+ * Kills every process in the container except the
+ * sleeping init process, so that a timed out test case
+ * doesn't keep running into the next one.
+ */
+func (b *box) KillAll() {
+	if err := exec.Command(b.podmanPath, "exec", b.name, "kill", "-9", "-1").Run(); err != nil {
+		log.Debug("Could not kill processes", "Name", b.name, "Error", err)
+	}
+}
+
+/**
+ * Force removes the container, killing it if it is still
+ * running, along with its project directory.
+ */
+func (b *box) Remove() {
+	if err := exec.Command(b.podmanPath, "rm", "--force", "--time", "0", b.name).Run(); err != nil {
+		metrics.PodmanFailures.WithLabelValues("rm").Inc()
+		log.Error("Could not remove container", "Name", b.name, "Error", err)
+	}
+
+	if b.projectDir != "" {
+		os.RemoveAll(b.projectDir)
+	}
+}
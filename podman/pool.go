@@ -0,0 +1,305 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package podman
+
+import (
+	"context"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"whipcode/metrics"
+	"whipcode/sandbox"
+
+	"github.com/charmbracelet/log"
+)
+
+/**
+ * Lifetime of pooled containers in seconds. Containers
+ * that can't fit an execution into what is left of it are
+ * discarded instead of used.
+ */
+const poolLifetime = 3600
+
+/**
+ * Interval at which idle pooled containers are checked,
+ * and the ones too close to the end of their lifetime are
+ * replaced.
+ */
+const poolReapInterval = time.Minute
+
+/**
+ * Label set on every container, with the pid of the
+ * process that started it as the value. Used to find the
+ * containers left behind on shutdown.
+ */
+const instanceLabel = "whipcode.instance"
+
+/**
+ * Label set on containers started for a request, with the
+ * request ID as the value. Pooled containers are started
+ * before their request arrives and don't carry it.
+ */
+const requestLabel = "whipcode.request"
+
+/**
+ * Creates a new podman runtime and starts filling the
+ * warm container pool if any language has one.
+ *
+ * @param podmanPath string Path to podman executable
+ * @param pools []PoolSpec Languages to keep warm
+ *   containers of
+ * @return *Runtime New Runtime instance
+ */
+func NewRuntime(podmanPath string, pools []PoolSpec) *Runtime {
+	rt := &Runtime{
+		podmanPath: podmanPath,
+		instance:   strconv.Itoa(os.Getpid()),
+		pool:       newPool(pools),
+	}
+
+	if rt.pool != nil {
+		for _, lp := range rt.pool.pools {
+			go rt.fill(lp)
+		}
+	}
+
+	return rt
+}
+
+/**
+ * Creates a warm container pool for the languages with a
+ * pool size. Returns nil if no language has one.
+ *
+ * @param specs []PoolSpec Languages to keep warm
+ * @return *Pool Pool object
+ */
+func newPool(specs []PoolSpec) *Pool {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Pool{ctx: ctx, cancel: cancel}
+
+	for _, spec := range specs {
+		if spec.Size <= 0 {
+			continue
+		}
+		p.pools = append(p.pools, &langPool{
+			spec:   spec,
+			idle:   make(chan *box, spec.Size),
+			refill: make(chan struct{}, 1),
+		})
+	}
+
+	if len(p.pools) == 0 {
+		cancel()
+		return nil
+	}
+	return p
+}
+
+/**
+ * Keeps the pool of a language filled with idle
+ * containers until the pool is closed, replacing the ones
+ * that can't take every execution anymore. Run as a
+ * goroutine.
+ *
+ * @param lp *langPool Pool of the language
+ */
+func (rt *Runtime) fill(lp *langPool) {
+	opt := sandbox.ExecutionOptions{
+		Entry:   lp.spec.Entry,
+		Run:     lp.spec.Run,
+		Compile: lp.spec.Compile,
+		Limits:  lp.spec.Limits,
+	}
+
+	ticker := time.NewTicker(poolReapInterval)
+	defer ticker.Stop()
+
+	for {
+		for len(lp.idle) < cap(lp.idle) {
+			boxID := strconv.Itoa(rand.Intn(9000000) + 1000000)
+			var b *box
+			err := sandbox.WriteProject(filepath.Join(".", "run", "run"+boxID), nil)
+			if err == nil {
+				if b, err = rt.createBox(boxID, opt, poolLifetime); err != nil {
+					b.Remove()
+				}
+			}
+			if err != nil {
+				log.Error("Could not start pooled container", "Language", lp.spec.Entry, "Error", err)
+				select {
+				case <-time.After(10 * time.Second):
+					continue
+				case <-rt.pool.ctx.Done():
+					return
+				}
+			}
+
+			if rt.pool.ctx.Err() != nil {
+				b.Remove()
+				return
+			}
+			lp.idle <- b
+		}
+
+		select {
+		case <-lp.refill:
+		case <-ticker.C:
+			reap(lp)
+		case <-rt.pool.ctx.Done():
+			return
+		}
+	}
+}
+
+/**
+ * Removes the idle containers of a language that don't
+ * have enough of their lifetime left for the longest
+ * execution of the language. The others keep their order.
+ *
+ * @param lp *langPool Pool of the language
+ */
+func reap(lp *langPool) {
+	for range len(lp.idle) {
+		var b *box
+		select {
+		case b = <-lp.idle:
+		default:
+			return
+		}
+
+		if b.expires(lp.spec.Lifetime) {
+			log.Debug("Replacing expiring pooled container", "Language", lp.spec.Entry, "Name", b.name)
+			b.Remove()
+			continue
+		}
+
+		select {
+		case lp.idle <- b:
+		default:
+			b.Remove()
+		}
+	}
+}
+
+/**
+ * Takes an idle container for the given execution from the
+ * pool. Only executions with the exact profile of a pooled
+ * language can use its containers.
+ *
+ * @param opt sandbox.ExecutionOptions Execution options
+ * @param lifetime int Maximum lifetime of the execution
+ * @return *box Idle container, nil on a miss
+ */
+func (rt *Runtime) take(opt sandbox.ExecutionOptions, lifetime int) *box {
+	if rt.pool == nil {
+		return nil
+	}
+
+	for _, lp := range rt.pool.pools {
+		if lp.spec.Entry != opt.Entry || lp.spec.Run != opt.Run || lp.spec.Compile != opt.Compile {
+			continue
+		}
+
+		limits := opt.Limits
+		limits.Output = lp.spec.Limits.Output
+
+		for limits == lp.spec.Limits {
+			var b *box
+			select {
+			case b = <-lp.idle:
+			default:
+			}
+			if b == nil {
+				break
+			}
+
+			select {
+			case lp.refill <- struct{}{}:
+			default:
+			}
+
+			if b.expires(lifetime) {
+				go b.Remove()
+				continue
+			}
+
+			rt.pool.hits.Add(1)
+			log.Debug("Pool hit", "RequestID", opt.RequestID, "Language", opt.Entry, "Name", b.name)
+			return b
+		}
+
+		rt.pool.misses.Add(1)
+		log.Debug("Pool miss", "RequestID", opt.RequestID, "Language", opt.Entry)
+		return nil
+	}
+
+	return nil
+}
+
+/**
+ * Stops refilling the pool and removes all idle
+ * containers, then force removes every container still
+ * carrying this process's label.
+ */
+func (rt *Runtime) Close() {
+	if rt.pool != nil {
+		rt.pool.cancel()
+		for _, lp := range rt.pool.pools {
+			for len(lp.idle) > 0 {
+				(<-lp.idle).Remove()
+			}
+		}
+	}
+
+	out, err := exec.Command(rt.podmanPath, "ps", "--all", "--quiet", "--filter", "label="+instanceLabel+"="+rt.instance).Output()
+	if err != nil {
+		metrics.PodmanFailures.WithLabelValues("ps").Inc()
+		log.Error("Could not list containers", "Error", err)
+		return
+	}
+
+	ids := strings.Fields(string(out))
+	if len(ids) == 0 {
+		return
+	}
+
+	log.Warn("Killing remaining containers", "Count", len(ids))
+	args := append([]string{"rm", "--force", "--time", "0"}, ids...)
+	if out, err := exec.Command(rt.podmanPath, args...).CombinedOutput(); err != nil {
+		metrics.PodmanFailures.WithLabelValues("rm").Inc()
+		log.Error("Could not remove containers", "Error", err, "Output", string(out))
+	}
+}
+
+/**
+ * Returns the number of executions that got a container
+ * from the pool and the number that had to start one.
+ *
+ * @return int64 Pool hits
+ * @return int64 Pool misses
+ */
+func (rt *Runtime) PoolStats() (int64, int64) {
+	if rt.pool == nil {
+		return 0, 0
+	}
+	return rt.pool.hits.Load(), rt.pool.misses.Load()
+}
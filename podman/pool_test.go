@@ -0,0 +1,49 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package podman
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReap(t *testing.T) {
+	lp := &langPool{
+		spec: PoolSpec{Entry: "python", Lifetime: 10},
+		idle: make(chan *box, 4),
+	}
+
+	ages := map[string]time.Duration{
+		"expired":  (poolLifetime + 5) * time.Second,
+		"fresh":    0,
+		"expiring": (poolLifetime - 5) * time.Second,
+		"old":      (poolLifetime - 60) * time.Second,
+	}
+	for _, name := range []string{"expired", "fresh", "expiring", "old"} {
+		lp.idle <- &box{podmanPath: "true", name: name, started: time.Now().Add(-ages[name])}
+	}
+
+	reap(lp)
+
+	var kept []string
+	for len(lp.idle) > 0 {
+		kept = append(kept, (<-lp.idle).name)
+	}
+	if len(kept) != 2 || kept[0] != "fresh" || kept[1] != "old" {
+		t.Fatalf("kept %v, want [fresh old]", kept)
+	}
+}
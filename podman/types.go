@@ -17,42 +17,114 @@
 package podman
 
 import (
-	"github.com/karlseguin/ccache/v3"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"whipcode/sandbox"
 )
 
 /**
- * Struct for defining an executor.
+ * Struct for the podman runtime.
  *
- * @field timeout int Timeout for the executor
  * @field podmanPath string Path to the podman executable
- * @field execCache *ccache.Cache[map[string]interface{}]
- *   Cache for the executor
+ * @field instance string Label value on every container
+ *   started by this process
+ * @field pool *Pool Warm containers, nil if disabled
+ * @field images sync.Map Recently looked up image IDs,
+ *   keyed by entry
  */
-type Executor struct {
-	timeout    int
+type Runtime struct {
 	podmanPath string
-	execCache  *ccache.Cache[map[string]interface{}]
+	instance   string
+	pool       *Pool
+	images     sync.Map
+}
+
+/**
+ * Struct for a looked up image ID.
+ *
+ * @field id string Image ID
+ * @field fetched time.Time Time it was looked up
+ */
+type imageID struct {
+	id      string
+	fetched time.Time
+}
+
+/**
+ * Struct for a started sandbox container.
+ *
+ * @field podmanPath string Path to the podman executable
+ * @field name string Container name
+ * @field cgroup string Cgroup path of the container
+ * @field started time.Time Time the container was started
+ * @field projectDir string Temp directory mounted into the
+ *   container as its working directory
+ * @field env map[string]string Environment variables passed
+ *   to podman exec
+ */
+type box struct {
+	podmanPath string
+	name       string
+	cgroup     string
+	started    time.Time
+	projectDir string
+	env        map[string]string
+}
+
+/**
+ * Struct for the warm containers of a language.
+ *
+ * @field Entry string Entry point, also names the image
+ * @field Run string Run script
+ * @field Compile string Compile script, may be empty
+ * @field Limits sandbox.Limits Resource limits of the
+ *   containers
+ * @field Size int Number of idle containers to keep
+ * @field Lifetime int Longest lifetime in seconds an
+ *   execution of the language can ask for, idle containers
+ *   with less than that left are replaced
+ */
+type PoolSpec struct {
+	Entry    string
+	Run      string
+	Compile  string
+	Limits   sandbox.Limits
+	Size     int
+	Lifetime int
+}
+
+/**
+ * Struct for the idle containers of a single language.
+ *
+ * @field spec PoolSpec Language of the containers
+ * @field idle chan *box Idle containers
+ * @field refill chan struct{} Signalled when a container
+ *   was taken
+ */
+type langPool struct {
+	spec   PoolSpec
+	idle   chan *box
+	refill chan struct{}
 }
 
 /**
- * Struct for defining execution options.
+ * Struct for the warm container pool of an executor.
  *
- * @field Code string Code to run
- * @field Entry string Entry point
- * @field Args string Compiler/interpreter arguments
- * @field Stdin string Standard input
- * @field Ext string File extension
- * @field Timeout int Execution timeout
- * @field Env map[string]string Environment variables
- * @field EnableCache bool Enable cache
+ * @field pools []*langPool Pools of each language
+ * @field hits atomic.Int64 Executions that got a container
+ *   from the pool
+ * @field misses atomic.Int64 Executions of pooled languages
+ *   that had to start a container
+ * @field ctx context.Context Cancelled when the pool closes
+ * @field cancel context.CancelFunc Closes the pool
  */
-type ExecutionOptions struct {
-	Code        string
-	Entry       string
-	Args        string
-	Stdin       string
-	Ext         string
-	Timeout     int
-	Env         map[string]string
-	EnableCache bool
+type Pool struct {
+	pools  []*langPool
+	hits   atomic.Int64
+	misses atomic.Int64
+	ctx    context.Context
+	cancel context.CancelFunc
 }
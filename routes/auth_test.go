@@ -0,0 +1,194 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package routes
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"whipcode/control"
+	"whipcode/fake"
+	"whipcode/sandbox"
+	"whipcode/server"
+)
+
+/**
+ * Creates the handler for /run, backed by a fake executor,
+ * with a key alpha that may only run python with a max
+ * timeout of 2, and a key beta without restrictions.
+ *
+ * @param t *testing.T Test
+ * @param modify func(*server.ScopedMiddlewareParams)
+ *   Changes to the parameters, may be nil
+ * @return http.Handler Handler
+ * @return *fake.Executor Fake executor
+ */
+func newAuthHandler(t *testing.T, modify func(*server.ScopedMiddlewareParams)) (http.Handler, *fake.Executor) {
+	t.Helper()
+
+	registry := control.Registry{Keys: []control.RegistryEntry{
+		{
+			ID:         "alpha",
+			Hash:       control.HashSecret("alpha-secret", "alpha-salt"),
+			Salt:       "alpha-salt",
+			Languages:  []string{"python"},
+			MaxTimeout: 2,
+		},
+		{
+			ID:   "beta",
+			Hash: control.HashSecret("beta-secret", "beta-salt"),
+			Salt: "beta-salt",
+		},
+	}}
+	registryFile := filepath.Join(t.TempDir(), "keys.toml")
+	if err := registry.Write(registryFile); err != nil {
+		t.Fatal(err)
+	}
+	ks, err := control.InitializeKeystore(filepath.Join(t.TempDir(), ".masterkey"), registryFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	executor := fake.NewExecutor()
+	params := &server.ScopedMiddlewareParams{
+		LangMap: server.LangMap{
+			"1": {Entry: "python", Ext: "py", Run: "python"},
+			"2": {Entry: "nodejs", Ext: "js", Run: "nodejs"},
+		},
+		MaxBytesSize: 1 << 20,
+		KeyStore:     ks,
+		Executor:     executor,
+	}
+	if modify != nil {
+		modify(params)
+	}
+
+	var state atomic.Pointer[server.ScopedMiddlewareParams]
+	state.Store(params)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /run", server.ScopedMiddleware(Run, &state))
+	return server.Middleware(mux, server.MiddlewareParams{Draining: &atomic.Bool{}}), executor
+}
+
+/**
+ * Sends a request to /run with the given headers.
+ *
+ * @param handler http.Handler Handler
+ * @param body string Request body
+ * @param header map[string]string Request headers
+ * @return *httptest.ResponseRecorder Response
+ */
+func postRun(handler http.Handler, body string, header map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/run", bytes.NewBufferString(body))
+	r.Header.Set("Content-Type", "application/json")
+	for name, value := range header {
+		r.Header.Set(name, value)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+/**
+ * Returns a request body that runs print(1).
+ *
+ * @param languageID string Language ID
+ * @param extra string Extra JSON fields, may be empty
+ * @return string Request body
+ */
+func runBody(languageID, extra string) string {
+	body := `{"language_id": "` + languageID + `", "code": "` + base64.StdEncoding.EncodeToString([]byte("print(1)")) + `"`
+	if extra != "" {
+		body += ", " + extra
+	}
+	return body + "}"
+}
+
+func TestRunUnauthorized(t *testing.T) {
+	handler, _ := newAuthHandler(t, nil)
+
+	for name, header := range map[string]map[string]string{
+		"missing key":  nil,
+		"wrong secret": {"X-Master-Key": "alpha.wrong"},
+		"unknown key":  {"X-Master-Key": "gamma.alpha-secret"},
+	} {
+		w := postRun(handler, runBody("1", ""), header)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status %d, want 401", name, w.Code)
+		}
+	}
+}
+
+func TestRunKeyScopes(t *testing.T) {
+	handler, executor := newAuthHandler(t, nil)
+
+	var opt sandbox.ExecutionOptions
+	executor.Handler = func(ctx context.Context, o sandbox.ExecutionOptions) sandbox.Result {
+		opt = o
+		return sandbox.Result{Status: http.StatusOK, Body: map[string]interface{}{}}
+	}
+
+	w := postRun(handler, runBody("1", `"timeout": 5`), map[string]string{"X-Master-Key": "alpha.alpha-secret"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", w.Code, w.Body.String())
+	}
+	if opt.Entry != "python" || opt.Timeout != 5 || opt.KeyMaxTimeout != 2 {
+		t.Errorf("entry %q, timeout %d, key max timeout %d, want python, 5, 2", opt.Entry, opt.Timeout, opt.KeyMaxTimeout)
+	}
+
+	w = postRun(handler, runBody("2", ""), map[string]string{"X-Master-Key": "alpha.alpha-secret"})
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusBadRequest ||
+		body["detail"] != "invalid value for parameter language_id, not allowed for this key" {
+		t.Errorf("status %d, body %s, want the language to be rejected", w.Code, w.Body.String())
+	}
+
+	w = postRun(handler, runBody("2", ""), map[string]string{"X-Master-Key": "beta.beta-secret"})
+	if w.Code != http.StatusOK || opt.Entry != "nodejs" || opt.KeyMaxTimeout != 0 {
+		t.Errorf("status %d, entry %q, key max timeout %d, want 200, nodejs, 0", w.Code, opt.Entry, opt.KeyMaxTimeout)
+	}
+}
+
+func TestRunBanned(t *testing.T) {
+	handler, _ := newAuthHandler(t, func(params *server.ScopedMiddlewareParams) {
+		params.AuthGuard = control.NewAuthGuard(2, 300, 900)
+	})
+
+	for range 2 {
+		if w := postRun(handler, runBody("1", ""), map[string]string{"X-Master-Key": "alpha.wrong"}); w.Code != http.StatusUnauthorized {
+			t.Fatalf("status %d, want 401", w.Code)
+		}
+	}
+
+	w := postRun(handler, runBody("1", ""), map[string]string{"X-Master-Key": "alpha.alpha-secret"})
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status %d, want 429", w.Code)
+	}
+	if retryAfter, _ := strconv.Atoi(w.Header().Get("Retry-After")); retryAfter <= 0 || retryAfter > 900 {
+		t.Errorf("Retry-After %q, want up to 900", w.Header().Get("Retry-After"))
+	}
+}
@@ -0,0 +1,128 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"whipcode/jobs"
+	"whipcode/sandbox"
+	"whipcode/server"
+)
+
+/**
+ * Endpoint for submitting an execution as a background
+ * job. Accepts the same body as /run and replies with
+ * the job ID right away, or with 429 or 503 if the key or
+ * the server has too many running jobs.
+ *
+ * @param w http.ResponseWriter Response writer
+ * @param r *http.Request Request object
+ */
+func SubmitJob(w http.ResponseWriter, r *http.Request) {
+	r, ok := authorize(w, r)
+	if !ok {
+		return
+	}
+
+	executionOptions, ok := parseExecution(w, r)
+	if !ok {
+		return
+	}
+
+	ex, _ := r.Context().Value(server.ExecutorContextKey).(sandbox.Executor)
+	store, _ := r.Context().Value(server.JobStoreContextKey).(*jobs.Store)
+
+	requestID := executionOptions.RequestID
+	id, err := store.Submit(server.Info(r).KeyID, func(ctx context.Context) (int, map[string]interface{}) {
+		result := ex.Run(ctx, executionOptions)
+		if result.Body != nil {
+			result.Body["request_id"] = requestID
+		}
+		return result.Status, result.Body
+	})
+	switch {
+	case errors.Is(err, jobs.ErrTooManyKeyJobs):
+		server.Send(w, http.StatusTooManyRequests, []byte(`{"detail": "too many running jobs for this key"}`))
+		return
+	case errors.Is(err, jobs.ErrTooManyJobs):
+		w.Header().Set("Retry-After", strconv.Itoa(ex.RetryAfter()))
+		server.Send(w, http.StatusServiceUnavailable, []byte(`{"detail": "too many running jobs"}`))
+		return
+	}
+
+	resultBytes, _ := json.Marshal(map[string]string{"id": id, "status": "running", "request_id": requestID})
+	server.Send(w, http.StatusAccepted, resultBytes)
+}
+
+/**
+ * Endpoint for polling the status and result of a job.
+ *
+ * @param w http.ResponseWriter Response writer
+ * @param r *http.Request Request object
+ */
+func GetJob(w http.ResponseWriter, r *http.Request) {
+	r, ok := authorize(w, r)
+	if !ok {
+		return
+	}
+
+	store, _ := r.Context().Value(server.JobStoreContextKey).(*jobs.Store)
+
+	job, exists := store.Get(r.PathValue("id"), server.Info(r).KeyID)
+	if !exists {
+		server.Send(w, http.StatusNotFound, []byte(`{"detail": "job not found"}`))
+		return
+	}
+
+	resultBytes, _ := json.Marshal(job)
+	server.Send(w, http.StatusOK, resultBytes)
+}
+
+/**
+ * Endpoint for cancelling a running job. Kills the
+ * container of the job.
+ *
+ * @param w http.ResponseWriter Response writer
+ * @param r *http.Request Request object
+ */
+func CancelJob(w http.ResponseWriter, r *http.Request) {
+	r, ok := authorize(w, r)
+	if !ok {
+		return
+	}
+
+	store, _ := r.Context().Value(server.JobStoreContextKey).(*jobs.Store)
+
+	job, exists, cancelled := store.Cancel(r.PathValue("id"), server.Info(r).KeyID)
+	if !exists {
+		server.Send(w, http.StatusNotFound, []byte(`{"detail": "job not found"}`))
+		return
+	}
+
+	if !cancelled {
+		server.Send(w, http.StatusConflict, []byte(`{"detail": "job already finished"}`))
+		return
+	}
+
+	resultBytes, _ := json.Marshal(job)
+	server.Send(w, http.StatusOK, resultBytes)
+}
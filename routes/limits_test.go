@@ -0,0 +1,117 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"whipcode/sandbox"
+	"whipcode/server"
+)
+
+func TestDecodeLimits(t *testing.T) {
+	profile := sandbox.Limits{Memory: 256 << 20, CPUs: 1, Pids: 64}
+	ceilings := sandbox.Limits{Memory: 512 << 20, CPUs: 2, Pids: 128}
+
+	tests := []struct {
+		body     string
+		ceilings sandbox.Limits
+		want     sandbox.Limits
+	}{
+		{`{}`, ceilings, profile},
+		{`{"cpu_limit": "1.5"}`, ceilings, sandbox.Limits{Memory: 256 << 20, CPUs: 1.5, Pids: 64}},
+		{`{"cpu_limit": 0.5}`, ceilings, sandbox.Limits{Memory: 256 << 20, CPUs: 0.5, Pids: 64}},
+		{`{"cpu_limit": 4}`, ceilings, sandbox.Limits{Memory: 256 << 20, CPUs: 2, Pids: 64}},
+		{`{"memory_limit": "1g", "pids_limit": 500}`, ceilings, sandbox.Limits{Memory: 512 << 20, CPUs: 1, Pids: 128}},
+		{`{"memory_limit": "64m", "pids_limit": "8"}`, ceilings, sandbox.Limits{Memory: 64 << 20, CPUs: 1, Pids: 8}},
+		{`{"max_output_bytes": "2m"}`, ceilings, sandbox.Limits{Memory: 256 << 20, CPUs: 1, Pids: 64, Output: 2 << 20}},
+		{`{"max_output_bytes": "2m"}`, sandbox.Limits{Output: 1 << 20}, sandbox.Limits{Memory: 256 << 20, CPUs: 1, Pids: 64, Output: 1 << 20}},
+		{`{}`, sandbox.Limits{Output: 1 << 20}, sandbox.Limits{Memory: 256 << 20, CPUs: 1, Pids: 64, Output: 1 << 20}},
+	}
+
+	for _, test := range tests {
+		var user User
+		if err := json.Unmarshal([]byte(test.body), &user); err != nil {
+			t.Fatalf("%s: %v", test.body, err)
+		}
+
+		limits, detail := decodeLimits(user, profile, test.ceilings)
+		if detail != "" || limits != test.want {
+			t.Errorf("%s: limits %+v, %q, want %+v", test.body, limits, detail, test.want)
+		}
+	}
+
+	for _, body := range []string{
+		`{"cpu_limit": "abc"}`,
+		`{"cpu_limit": 0}`,
+		`{"cpu_limit": "-1"}`,
+		`{"cpu_limit": "Inf"}`,
+		`{"cpu_limit": "NaN"}`,
+		`{"memory_limit": "1k"}`,
+		`{"pids_limit": 0}`,
+		`{"pids_limit": "1.5"}`,
+		`{"max_output_bytes": "-1"}`,
+	} {
+		var user User
+		if err := json.Unmarshal([]byte(body), &user); err != nil {
+			t.Fatalf("%s: %v", body, err)
+		}
+
+		if limits, detail := decodeLimits(user, profile, ceilings); detail == "" {
+			t.Errorf("%s: limits %+v, want an error", body, limits)
+		}
+	}
+}
+
+func TestFloatTolerance(t *testing.T) {
+	ctx := context.WithValue(context.Background(), server.LangMapContextKey, server.LangMap{"1": {Entry: "python", Ext: "py", Run: "python"}})
+	ctx = context.WithValue(ctx, server.MaxTestCasesContextKey, 1)
+	ctx = context.WithValue(ctx, server.EnableCacheContextKey, false)
+	r := httptest.NewRequest(http.MethodPost, "/run", nil).WithContext(ctx)
+
+	tests := []struct {
+		body string
+		want float64
+	}{
+		{`{}`, 1e-6},
+		{`{"float_tolerance": 0}`, 0},
+		{`{"float_tolerance": 0.01}`, 0.01},
+	}
+	for _, test := range tests {
+		var user User
+		if err := json.Unmarshal([]byte(test.body), &user); err != nil {
+			t.Fatalf("%s: %v", test.body, err)
+		}
+		user.LanguageID.value = "1"
+		user.Code = "eA=="
+
+		opt, detail := buildExecution(r, user)
+		if detail != "" || opt.FloatTolerance != test.want {
+			t.Errorf("%s: tolerance %g, %q, want %g", test.body, opt.FloatTolerance, detail, test.want)
+		}
+	}
+
+	var user User
+	json.Unmarshal([]byte(`{"language_id": "1", "code": "eA==", "float_tolerance": -1}`), &user)
+	if _, detail := buildExecution(r, user); detail == "" {
+		t.Error("negative tolerance accepted")
+	}
+}
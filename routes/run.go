@@ -17,30 +17,39 @@
 package routes
 
 import (
+	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"math"
 	"net/http"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 
 	"github.com/charmbracelet/log"
 
+	"whipcode/audit"
 	"whipcode/control"
-	"whipcode/podman"
+	"whipcode/metrics"
+	"whipcode/sandbox"
 	"whipcode/server"
 )
 
 /**
- * Helper function for accepting a string or int value.
+ * Helper function for accepting a string or number value.
  *
  * @param l *StrInt StrInt object
  * @param b []byte Byte array
  * @return error Error object
  */
 func (l *StrInt) UnmarshalJSON(b []byte) error {
-	var intValue int
-	if err := json.Unmarshal(b, &intValue); err == nil {
-		l.value = strconv.Itoa(intValue)
+	var numberValue json.Number
+	if err := json.Unmarshal(b, &numberValue); err == nil {
+		l.value = numberValue.String()
 		return nil
 	}
 
@@ -53,81 +62,489 @@ func (l *StrInt) UnmarshalJSON(b []byte) error {
 	return json.Unmarshal(b, &l.value)
 }
 
+var validPath = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.\-]*(/[A-Za-z0-9_][A-Za-z0-9_.\-]*)*$`)
+
 /**
- * Run endpoint for running code in a container. This is
- * the main endpoint for the application.
- * Calls podman.RunCode
+ * Decodes the submitted source into project files. A
+ * single code string is stored as source.<ext>, while
+ * projects default to main.<ext> as their entry point.
+ *
+ * @param user User Decoded request body
+ * @param ext string File extension of the language
+ * @return []sandbox.SourceFile Decoded files
+ * @return string Entry point path
+ * @return string Error detail, empty on success
+ */
+func decodeSource(user User, ext string) ([]sandbox.SourceFile, string, string) {
+	if len(user.Files) == 0 {
+		codeBytes, err := base64.StdEncoding.DecodeString(user.Code)
+		if err != nil || user.Code == "" {
+			return nil, "", "invalid value for parameter code, must be a base64 encoded string"
+		}
+		return []sandbox.SourceFile{{Path: "source." + ext, Content: string(codeBytes)}}, "source." + ext, ""
+	}
+
+	if user.Code != "" {
+		return nil, "", "parameters code and files are mutually exclusive"
+	}
+
+	entryPoint := user.EntryPoint
+	if entryPoint == "" {
+		entryPoint = "main." + ext
+	}
+
+	files := make([]sandbox.SourceFile, 0, len(user.Files))
+	seen := make(map[string]bool, len(user.Files))
+	for i, file := range user.Files {
+		if !validPath.MatchString(file.Path) || !filepath.IsLocal(file.Path) || seen[file.Path] {
+			return nil, "", fmt.Sprintf("invalid value for parameter files[%d].path, must be a unique relative path", i)
+		}
+		seen[file.Path] = true
+
+		contentBytes, err := base64.StdEncoding.DecodeString(file.Content)
+		if err != nil {
+			return nil, "", fmt.Sprintf("invalid value for parameter files[%d].content, must be a base64 encoded string", i)
+		}
+		files = append(files, sandbox.SourceFile{Path: file.Path, Content: string(contentBytes)})
+	}
+
+	for i, file := range user.Files {
+		for dir := filepath.Dir(file.Path); dir != "."; dir = filepath.Dir(dir) {
+			if seen[dir] {
+				return nil, "", fmt.Sprintf("invalid value for parameter files[%d].path, %s is a file and can't be a directory", i, dir)
+			}
+		}
+	}
+
+	if !seen[entryPoint] {
+		return nil, "", "invalid value for parameter entrypoint, must be one of the submitted files"
+	}
+
+	return files, entryPoint, ""
+}
+
+/**
+ * Returns the standard input of a request or test case,
+ * decoding stdin_b64 if it is set.
+ *
+ * @param stdin string Plain standard input
+ * @param stdinB64 string Base64 encoded standard input
+ * @param param string Parameter name for error details
+ * @return string Standard input
+ * @return string Error detail, empty on success
+ */
+func decodeStdin(stdin, stdinB64, param string) (string, string) {
+	if stdinB64 == "" {
+		return stdin, ""
+	}
+
+	if stdin != "" {
+		return "", fmt.Sprintf("parameters %s and %s_b64 are mutually exclusive", param, param)
+	}
+
+	stdinBytes, err := base64.StdEncoding.DecodeString(stdinB64)
+	if err != nil {
+		return "", fmt.Sprintf("invalid value for parameter %s_b64, must be a base64 encoded string", param)
+	}
+
+	return string(stdinBytes), ""
+}
+
+/**
+ * Validates and converts the submitted test cases.
+ *
+ * @param user User Decoded request body
+ * @param maxTestCases int Maximum number of test cases
+ * @return []sandbox.TestCase Converted test cases
+ * @return string Error detail, empty on success
+ */
+func decodeTestCases(user User, maxTestCases int) ([]sandbox.TestCase, string) {
+	if len(user.TestCases) > maxTestCases {
+		return nil, fmt.Sprintf("invalid value for parameter test_cases, at most %d test cases are allowed", maxTestCases)
+	}
+
+	switch user.CompareMode {
+	case "", "exact", "trimmed", "whitespace", "float":
+	default:
+		return nil, "invalid value for parameter compare_mode, must be one of exact, trimmed, whitespace, float"
+	}
+
+	if user.FloatTolerance != nil && *user.FloatTolerance < 0 {
+		return nil, "invalid value for parameter float_tolerance, must not be negative"
+	}
+
+	testCases := make([]sandbox.TestCase, 0, len(user.TestCases))
+	for i, testCase := range user.TestCases {
+		timeout := 0
+		if testCase.Timeout.value != "" {
+			t, err := strconv.Atoi(testCase.Timeout.value)
+			if err != nil || t < 0 {
+				return nil, fmt.Sprintf("invalid value for parameter test_cases[%d].timeout, must be an integer", i)
+			}
+			timeout = t
+		}
+		stdin, detail := decodeStdin(testCase.Stdin, testCase.StdinB64, fmt.Sprintf("test_cases[%d].stdin", i))
+		if detail != "" {
+			return nil, detail
+		}
+
+		testCases = append(testCases, sandbox.TestCase{
+			Stdin:          stdin,
+			ExpectedStdout: testCase.ExpectedStdout,
+			Timeout:        timeout,
+		})
+	}
+
+	return testCases, ""
+}
+
+/**
+ * Checks the key or the signature of the request. Sends
+ * an error response if the request is not authorized,
+ * otherwise adds the key to the context of the request.
  *
  * @param w http.ResponseWriter Response writer
  * @param r *http.Request Request object
+ * @return *http.Request Request carrying the key
+ * @return bool True if the request may continue
  */
-func Run(w http.ResponseWriter, r *http.Request) {
-	masterKey := r.Header.Get("X-Master-Key")
+func authorize(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	userKey := r.Header.Get("X-Master-Key")
+	signature := r.Header.Get("X-Signature")
+	info := server.Info(r)
 
-	if masterKey == "" {
-		log.Warn("Blocked the last request", "Reason", "missing master key")
+	if userKey == "" && signature == "" {
+		log.Warn("Blocked the last request", "RequestID", info.ID, "Reason", "missing key")
 		server.Send(w, http.StatusUnauthorized, []byte(`{"detail": "unauthorized"}`))
-		return
+		return r, false
+	}
+
+	guard, _ := r.Context().Value(server.AuthGuardContextKey).(*control.AuthGuard)
+	if remaining := guard.Banned(info.ClientIP); remaining > 0 {
+		metrics.AuthBanned.Inc()
+		log.Warn("Blocked the last request", "RequestID", info.ID, "IP", info.ClientIP, "Reason", "banned")
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(remaining.Seconds()))))
+		server.Send(w, http.StatusTooManyRequests, []byte(`{"detail": "too many failed authentication attempts"}`))
+		return r, false
 	}
 
 	ks, _ := r.Context().Value(server.KeyStoreContextKey).(*control.KeyStore)
-	if !ks.CheckKey(masterKey, r.Context().Value(server.MasterKeyContextKey).([]string)) {
-		log.Warn("Blocked the last request", "Reason", "invalid master key")
+	var key *control.Key
+	reason := ""
+	if signature != "" {
+		var err error
+		if key, reason, err = checkSignature(r, ks, signature); err != nil {
+			server.Send(w, http.StatusBadRequest, []byte(`{"detail": "invalid request format"}`))
+			return r, false
+		}
+	} else if key = ks.CheckKey(userKey); key == nil {
+		reason = "invalid key"
+	}
+	if reason == "" && !key.Enabled {
+		reason = "disabled key"
+	}
+
+	if reason != "" {
+		metrics.AuthFailures.WithLabelValues(reason).Inc()
+		fields := []interface{}{"RequestID", info.ID, "IP", info.ClientIP, "Reason", reason}
+		if key != nil {
+			fields = append(fields, "Key", key.ID)
+		}
+		log.Warn("Blocked the last request", fields...)
+
+		if failures, banned := guard.Fail(info.ClientIP); banned {
+			metrics.AuthBans.Inc()
+			log.Warn("Banned client for failed authentication attempts", "IP", info.ClientIP, "Failures", failures, "Duration", guard.BanTime())
+		}
 		server.Send(w, http.StatusUnauthorized, []byte(`{"detail": "unauthorized"}`))
-		return
+		return r, false
 	}
 
-	mimeType := r.Header.Get("Content-Type")
-	if strings.Split(mimeType, ";")[0] != "application/json" {
-		server.Send(w, http.StatusUnsupportedMediaType, []byte(`{"detail": "unsupported media type"}`))
-		return
+	guard.Succeed(info.ClientIP)
+	info.KeyID = key.ID
+	return r.WithContext(context.WithValue(r.Context(), server.KeyContextKey, key)), true
+}
+
+/**
+ * Sends a 400 response with the given detail.
+ *
+ * @param w http.ResponseWriter Response writer
+ * @param detail string Details about the error
+ */
+func badRequest(w http.ResponseWriter, detail string) {
+	detailBytes, _ := json.Marshal(map[string]string{"detail": detail})
+	server.Send(w, http.StatusBadRequest, detailBytes)
+}
+
+/**
+ * Applies the resource limits requested by the client to
+ * the profile of the language. Requested values are
+ * lowered to the configured ceilings.
+ *
+ * @param user User Decoded request body
+ * @param limits sandbox.Limits Limits of the language
+ * @param ceilings sandbox.Limits Configured ceilings
+ * @return sandbox.Limits Resulting limits
+ * @return string Error detail, empty on success
+ */
+func decodeLimits(user User, limits sandbox.Limits, ceilings sandbox.Limits) (sandbox.Limits, string) {
+	limits.Output = ceilings.Output
+
+	if user.MemoryLimit.value != "" {
+		memory, err := sandbox.ParseSize(user.MemoryLimit.value)
+		if err != nil || memory < sandbox.MinMemory {
+			return sandbox.Limits{}, "invalid value for parameter memory_limit, must be a size of at least 6m"
+		}
+		limits.Memory = min(memory, ceilings.Memory)
 	}
 
-	var user User
+	if user.CPULimit.value != "" {
+		cpus, err := strconv.ParseFloat(user.CPULimit.value, 64)
+		if err != nil || !(cpus > 0) || math.IsInf(cpus, 1) {
+			return sandbox.Limits{}, "invalid value for parameter cpu_limit, must be a positive number"
+		}
+		limits.CPUs = min(cpus, ceilings.CPUs)
+	}
 
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		server.Send(w, http.StatusBadRequest, []byte(`{"detail": "invalid request format"}`))
-		return
+	if user.PidsLimit.value != "" {
+		pids, err := strconv.Atoi(user.PidsLimit.value)
+		if err != nil || pids < 1 {
+			return sandbox.Limits{}, "invalid value for parameter pids_limit, must be a positive integer"
+		}
+		limits.Pids = min(pids, ceilings.Pids)
 	}
 
+	if user.MaxOutputBytes.value != "" {
+		maxOutput, err := sandbox.ParseSize(user.MaxOutputBytes.value)
+		if err != nil {
+			return sandbox.Limits{}, "invalid value for parameter max_output_bytes, must be a positive size"
+		}
+		limits.Output = maxOutput
+		if ceilings.Output > 0 {
+			limits.Output = min(maxOutput, ceilings.Output)
+		}
+	}
+
+	return limits, ""
+}
+
+/**
+ * Validates a decoded execution request and converts it
+ * into execution options. The language and timeout are
+ * restricted to what the key of the request allows.
+ *
+ * @param r *http.Request Request object
+ * @param user User Decoded request body
+ * @return sandbox.ExecutionOptions Execution options
+ * @return string Error detail, empty on success
+ */
+func buildExecution(r *http.Request, user User) (sandbox.ExecutionOptions, string) {
 	langMap, _ := r.Context().Value(server.LangMapContextKey).(server.LangMap)
 	langConfig, exists := langMap[user.LanguageID.value]
 	if !exists {
-		server.Send(w, http.StatusBadRequest, []byte(`{"detail": "invalid value for parameter language_id, refer to the documentation"}`))
-		return
+		return sandbox.ExecutionOptions{}, "invalid value for parameter language_id, refer to the documentation"
 	}
 
-	codeBytes, err := base64.StdEncoding.DecodeString(user.Code)
-	if err != nil || user.Code == "" {
-		server.Send(w, http.StatusBadRequest, []byte(`{"detail": "invalid value for parameter code, must be a base64 encoded string"}`))
-		return
+	key, _ := r.Context().Value(server.KeyContextKey).(*control.Key)
+	if key != nil && !key.AllowsLanguage(langConfig.Entry) {
+		return sandbox.ExecutionOptions{}, "invalid value for parameter language_id, not allowed for this key"
+	}
+
+	files, entryPoint, detail := decodeSource(user, langConfig.Ext)
+	if detail != "" {
+		return sandbox.ExecutionOptions{}, detail
+	}
+
+	maxTestCases, _ := r.Context().Value(server.MaxTestCasesContextKey).(int)
+	testCases, detail := decodeTestCases(user, maxTestCases)
+	if detail != "" {
+		return sandbox.ExecutionOptions{}, detail
+	}
+
+	stdin, detail := decodeStdin(user.Stdin, user.StdinB64, "stdin")
+	if detail != "" {
+		return sandbox.ExecutionOptions{}, detail
+	}
+
+	ceilings, _ := r.Context().Value(server.CeilingsContextKey).(sandbox.Limits)
+	limits, detail := decodeLimits(user, langConfig.Limits, ceilings)
+	if detail != "" {
+		return sandbox.ExecutionOptions{}, detail
+	}
+
+	floatTolerance := 1e-6
+	if user.FloatTolerance != nil {
+		floatTolerance = *user.FloatTolerance
 	}
 
 	timeout := 0
 	if user.Timeout.value != "" {
 		t, err := strconv.Atoi(user.Timeout.value)
 		if err != nil {
-			server.Send(w, http.StatusBadRequest, []byte(`{"detail": "invalid value for parameter timeout, must be an integer"}`))
-			return
+			return sandbox.ExecutionOptions{}, "invalid value for parameter timeout, must be an integer"
 		}
 		timeout = t
 	}
+	keyMaxTimeout := 0
+	if key != nil {
+		keyMaxTimeout = key.MaxTimeout
+	}
+
+	executionOptions := sandbox.ExecutionOptions{
+		Files:          files,
+		EntryPoint:     entryPoint,
+		Entry:          langConfig.Entry,
+		Compile:        langConfig.Compile,
+		Run:            langConfig.Run,
+		Args:           user.Args,
+		Stdin:          stdin,
+		Timeout:        timeout,
+		MaxTimeout:     langConfig.MaxTimeout,
+		KeyMaxTimeout:  keyMaxTimeout,
+		Limits:         limits,
+		Env:            user.Env,
+		TestCases:      testCases,
+		CompareMode:    user.CompareMode,
+		FloatTolerance: floatTolerance,
+		EnableCache:    r.Context().Value(server.EnableCacheContextKey).(bool),
+		RequestID:      server.Info(r).ID,
+	}
+	recordSubmission(r, executionOptions)
+
+	return executionOptions, ""
+}
+
+/**
+ * Adds the language of a validated execution to the access
+ * log line of the request and appends it to the audit log.
+ *
+ * @param r *http.Request Request object
+ * @param opt sandbox.ExecutionOptions Execution options
+ */
+func recordSubmission(r *http.Request, opt sandbox.ExecutionOptions) {
+	info := server.Info(r)
+	info.Language = opt.Entry
+
+	auditLog, _ := r.Context().Value(server.AuditLogContextKey).(*audit.Logger)
+	if auditLog == nil {
+		return
+	}
+
+	codeHash := sha256.New()
+	codeBytes := 0
+	for _, file := range opt.Files {
+		fmt.Fprintf(codeHash, "%d:%s%d:%s", len(file.Path), file.Path, len(file.Content), file.Content)
+		codeBytes += len(file.Content)
+	}
+
+	stdinHash := ""
+	if opt.Stdin != "" {
+		sum := sha256.Sum256([]byte(opt.Stdin))
+		stdinHash = hex.EncodeToString(sum[:])
+	}
+
+	auditLog.Record(audit.Entry{
+		RequestID:   info.ID,
+		KeyID:       info.KeyID,
+		ClientIP:    info.ClientIP,
+		Route:       r.Pattern,
+		Language:    opt.Entry,
+		CodeSHA256:  hex.EncodeToString(codeHash.Sum(nil)),
+		CodeBytes:   codeBytes,
+		Files:       len(opt.Files),
+		StdinSHA256: stdinHash,
+		TestCases:   len(opt.TestCases),
+	})
+}
+
+/**
+ * Adds the timeout and cached flags of a finished
+ * execution to the access log line of the request, and
+ * the request ID to the response body.
+ *
+ * @param r *http.Request Request object
+ * @param result sandbox.Result Result of the execution
+ */
+func recordResult(r *http.Request, result sandbox.Result) {
+	info := server.Info(r)
+	info.Timeout, _ = result.Body["timeout"].(bool)
+	info.Cached, _ = result.Body["cached"].(bool)
+	if result.Body != nil {
+		result.Body["request_id"] = info.ID
+	}
+}
+
+/**
+ * Decodes and validates the body of an execution request.
+ * Sends an error response if the body is invalid.
+ *
+ * @param w http.ResponseWriter Response writer
+ * @param r *http.Request Request object
+ * @return sandbox.ExecutionOptions Execution options
+ * @return bool True if the body is valid
+ */
+func parseExecution(w http.ResponseWriter, r *http.Request) (sandbox.ExecutionOptions, bool) {
+	mimeType := r.Header.Get("Content-Type")
+	if strings.Split(mimeType, ";")[0] != "application/json" {
+		server.Send(w, http.StatusUnsupportedMediaType, []byte(`{"detail": "unsupported media type"}`))
+		return sandbox.ExecutionOptions{}, false
+	}
+
+	var user User
+
+	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+		server.Send(w, http.StatusBadRequest, []byte(`{"detail": "invalid request format"}`))
+		return sandbox.ExecutionOptions{}, false
+	}
+
+	executionOptions, detail := buildExecution(r, user)
+	if detail != "" {
+		badRequest(w, detail)
+		return sandbox.ExecutionOptions{}, false
+	}
+
+	return executionOptions, true
+}
 
-	ex, _ := r.Context().Value(server.ExecutorContextKey).(podman.Executor)
+/**
+ * Run endpoint for running code in a container. This is
+ * the main endpoint for the application.
+ * Calls sandbox.Executor.Run
+ *
+ * @param w http.ResponseWriter Response writer
+ * @param r *http.Request Request object
+ */
+func Run(w http.ResponseWriter, r *http.Request) {
+	r, ok := authorize(w, r)
+	if !ok {
+		return
+	}
 
-	executionOptions := podman.ExecutionOptions{
-		Code:        string(codeBytes),
-		Entry:       langConfig["entry"],
-		Args:        user.Args,
-		Stdin:       user.Stdin,
-		Ext:         langConfig["ext"],
-		Timeout:     timeout,
-		Env:         user.Env,
-		EnableCache: r.Context().Value(server.EnableCacheContextKey).(bool),
+	executionOptions, ok := parseExecution(w, r)
+	if !ok {
+		return
 	}
 
-	status, result := ex.RunCode(executionOptions)
-	resultBytes, _ := json.Marshal(result)
+	ex, _ := r.Context().Value(server.ExecutorContextKey).(sandbox.Executor)
+
+	result := ex.Run(r.Context(), executionOptions)
+	recordResult(r, result)
+	sendResult(w, ex, result)
+}
+
+/**
+ * Sends the result of an execution as the response, with
+ * a Retry-After header if it was rejected.
+ *
+ * @param w http.ResponseWriter Response writer
+ * @param ex sandbox.Executor Executor that ran it
+ * @param result sandbox.Result Result of the execution
+ */
+func sendResult(w http.ResponseWriter, ex sandbox.Executor, result sandbox.Result) {
+	if result.Status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", strconv.Itoa(ex.RetryAfter()))
+	}
+	resultBytes, _ := json.Marshal(result.Body)
 
-	server.Send(w, status, resultBytes)
+	server.Send(w, result.Status, resultBytes)
 }
@@ -0,0 +1,214 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package routes
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/gorilla/websocket"
+
+	"whipcode/sandbox"
+	"whipcode/server"
+)
+
+/**
+ * Max time a message to the client may take to write
+ * before the session is closed.
+ */
+const sessionWriteTimeout = 10 * time.Second
+
+/**
+ * Endpoint for interactive sessions over WebSocket. The
+ * first message from the client is the same body /run
+ * accepts, after which the client sends stdin messages
+ * and receives output as it is produced. The session is
+ * ended with a result or error message.
+ *
+ * Client messages, stdin is ignored after eof:
+ *   {"type": "stdin", "data": "..."}
+ *   {"type": "eof"}
+ *
+ * Server messages:
+ *   {"type": "stdout" | "stderr", "phase": "...", "data": "...", "timestamp": "..."}
+ *   {"type": "result", ...}
+ *   {"type": "error", "detail": "...", "request_id": "..."}
+ *
+ * @param w http.ResponseWriter Response writer
+ * @param r *http.Request Request object
+ */
+func Session(w http.ResponseWriter, r *http.Request) {
+	r, ok := authorize(w, r)
+	if !ok {
+		return
+	}
+
+	origins, _ := r.Context().Value(server.SessionOriginsContextKey).([]string)
+	upgrader := websocket.Upgrader{CheckOrigin: allowOrigin(origins)}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Debug("Could not upgrade connection", "Error", err)
+		return
+	}
+	defer conn.Close()
+
+	var mu sync.Mutex
+	send := func(message map[string]interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		conn.SetWriteDeadline(time.Now().Add(sessionWriteTimeout))
+		if err := conn.WriteJSON(message); err != nil {
+			log.Debug("Failed to write message", "Error", err)
+			conn.Close()
+		}
+	}
+
+	var user User
+	if err := conn.ReadJSON(&user); err != nil {
+		send(map[string]interface{}{"type": "error", "detail": "invalid request format", "request_id": server.Info(r).ID})
+		return
+	}
+
+	executionOptions, detail := buildExecution(r, user)
+	if detail == "" && len(executionOptions.TestCases) > 0 {
+		detail = "parameter test_cases is not supported in sessions"
+	}
+	if detail != "" {
+		send(map[string]interface{}{"type": "error", "detail": detail, "request_id": server.Info(r).ID})
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	stdinReader, stdinWriter := io.Pipe()
+	defer stdinReader.Close()
+	defer stdinWriter.Close()
+
+	var lastActivity atomic.Int64
+	lastActivity.Store(time.Now().UnixNano())
+
+	executionOptions.EnableCache = false
+	executionOptions.StdinReader = stdinReader
+	executionOptions.OnOutput = func(phase, stream string, chunk []byte) {
+		lastActivity.Store(time.Now().UnixNano())
+		send(map[string]interface{}{
+			"type":      stream,
+			"phase":     phase,
+			"data":      string(chunk),
+			"timestamp": time.Now().UTC().Format(time.RFC3339Nano),
+		})
+	}
+
+	go func() {
+		defer cancel()
+		stdinClosed := false
+		for {
+			var message SessionMessage
+			if err := conn.ReadJSON(&message); err != nil {
+				return
+			}
+			lastActivity.Store(time.Now().UnixNano())
+
+			switch message.Type {
+			case "stdin":
+				// Input after eof or after the program closed its
+				// stdin is dropped, the execution keeps running
+				if !stdinClosed {
+					_, err := stdinWriter.Write([]byte(message.Data))
+					stdinClosed = err != nil
+				}
+			case "eof":
+				stdinWriter.Close()
+				stdinClosed = true
+			}
+		}
+	}()
+
+	idleTimeout, _ := r.Context().Value(server.IdleTimeoutContextKey).(int)
+	var idle atomic.Bool
+	go func() {
+		if idleTimeout <= 0 {
+			return
+		}
+
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if time.Since(time.Unix(0, lastActivity.Load())) >= time.Duration(idleTimeout)*time.Second {
+					idle.Store(true)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	ex, _ := r.Context().Value(server.ExecutorContextKey).(sandbox.Executor)
+
+	result := ex.Run(ctx, executionOptions)
+	recordResult(r, result)
+	switch {
+	case idle.Load():
+		send(map[string]interface{}{"type": "error", "detail": "session idle timeout", "request_id": server.Info(r).ID})
+	case result.Status != http.StatusOK:
+		result.Body["type"] = "error"
+		send(result.Body)
+	default:
+		result.Body["type"] = "result"
+		send(result.Body)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(sessionWriteTimeout))
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+}
+
+/**
+ * Returns the origin check for session handshakes. Clients
+ * that don't send an Origin header, pages served from the
+ * same host and the configured origins are allowed.
+ *
+ * @param origins []string Allowed origins, "*" allows any
+ * @return func(*http.Request) bool Origin check
+ */
+func allowOrigin(origins []string) func(*http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" || slices.Contains(origins, "*") || slices.Contains(origins, origin) {
+			return true
+		}
+
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+}
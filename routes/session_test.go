@@ -0,0 +1,121 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package routes
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"whipcode/control"
+	"whipcode/fake"
+	"whipcode/sandbox"
+	"whipcode/server"
+)
+
+/**
+ * Starts a server for /session, backed by the given
+ * executor and authorized with the master key "secret",
+ * and opens a session on it.
+ *
+ * @param t *testing.T Test
+ * @param executor *fake.Executor Fake executor
+ * @return *websocket.Conn Session connection
+ */
+func openSession(t *testing.T, executor *fake.Executor) *websocket.Conn {
+	t.Helper()
+
+	keyFile := filepath.Join(t.TempDir(), ".masterkey")
+	if err := os.WriteFile(keyFile, []byte(control.HashSecret("secret", "salt")+"\nsalt"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ks, err := control.InitializeKeystore(keyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var state atomic.Pointer[server.ScopedMiddlewareParams]
+	state.Store(&server.ScopedMiddlewareParams{
+		LangMap:      server.LangMap{"1": {Entry: "python", Ext: "py", Run: "python"}},
+		MaxBytesSize: 1 << 20,
+		KeyStore:     ks,
+		Executor:     executor,
+		IdleTimeout:  30,
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /session", server.ScopedMiddleware(Session, &state))
+	srv := httptest.NewServer(server.Middleware(mux, server.MiddlewareParams{Draining: &atomic.Bool{}}))
+	t.Cleanup(srv.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/session", http.Header{"X-Master-Key": {"secret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	code := base64.StdEncoding.EncodeToString([]byte("print(input())"))
+	if err := conn.WriteJSON(map[string]string{"language_id": "1", "code": code}); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func TestSessionStdinAfterEOF(t *testing.T) {
+	executor := fake.NewExecutor()
+	executor.Handler = func(ctx context.Context, opt sandbox.ExecutionOptions) sandbox.Result {
+		stdin, _ := io.ReadAll(opt.StdinReader)
+
+		// Give the late messages time to cancel the execution
+		select {
+		case <-ctx.Done():
+			return sandbox.Result{Status: sandbox.StatusCancelled, Body: map[string]interface{}{"detail": "execution cancelled"}}
+		case <-time.After(500 * time.Millisecond):
+		}
+		return sandbox.Result{Status: http.StatusOK, Body: map[string]interface{}{"stdout": string(stdin)}}
+	}
+
+	conn := openSession(t, executor)
+	for _, message := range []map[string]string{
+		{"type": "stdin", "data": "hello"},
+		{"type": "eof"},
+		{"type": "stdin", "data": "late"},
+		{"type": "eof"},
+	} {
+		if err := conn.WriteJSON(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var result map[string]interface{}
+	if err := conn.ReadJSON(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result["type"] != "result" || result["stdout"] != "hello" {
+		t.Errorf("got %v, want a result with the stdin sent before eof", result)
+	}
+}
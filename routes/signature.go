@@ -0,0 +1,88 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package routes
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"whipcode/control"
+	"whipcode/server"
+)
+
+/**
+ * Pattern nonces of signed requests must match.
+ */
+var noncePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{16,64}$`)
+
+/**
+ * Checks the signature of a signed request, made with the
+ * signing secret of the key in X-Key-ID over the string
+ * built by control.SigningString. The body is read to hash
+ * it and put back for the handler.
+ *
+ * @param r *http.Request Request object
+ * @param ks *control.KeyStore Key store
+ * @param signature string Hex encoded HMAC-SHA256 from
+ *   X-Signature
+ * @return *control.Key Signing key, nil if the signature
+ *   is invalid
+ * @return string Reason the request is rejected, empty if
+ *   it is valid
+ * @return error Error reading the body
+ */
+func checkSignature(r *http.Request, ks *control.KeyStore, signature string) (*control.Key, string, error) {
+	replayCache, _ := r.Context().Value(server.ReplayCacheContextKey).(*control.ReplayCache)
+	if replayCache == nil {
+		return nil, "signing disabled", nil
+	}
+
+	keyID := r.Header.Get("X-Key-ID")
+	timestamp := r.Header.Get("X-Timestamp")
+	nonce := r.Header.Get("X-Nonce")
+
+	if !noncePattern.MatchString(nonce) {
+		return nil, "invalid nonce", nil
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || !replayCache.Fresh(time.Unix(unix, 0)) {
+		return nil, "stale timestamp", nil
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	key := ks.SigningKey(keyID)
+	message := control.SigningString(r.Method, r.URL.RequestURI(), keyID, timestamp, nonce, body)
+	if key == nil || !key.VerifySignature(message, signature) {
+		return nil, "invalid signature", nil
+	}
+
+	if replayCache.Seen(key.ID, nonce, time.Unix(unix, 0)) {
+		return key, "replayed nonce", nil
+	}
+
+	return key, "", nil
+}
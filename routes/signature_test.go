@@ -0,0 +1,133 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package routes
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"whipcode/control"
+	"whipcode/fake"
+	"whipcode/server"
+)
+
+/**
+ * Signing secret of the signer key.
+ */
+const signerSecret = "0123456789abcdef0123456789abcdef"
+
+/**
+ * Creates the handler for /run, backed by a fake executor,
+ * with a key signer that can sign requests.
+ *
+ * @param t *testing.T Test
+ * @param maxAge int Max age of signed requests, 0 disables
+ *   them
+ * @return http.Handler Handler
+ */
+func newSignedHandler(t *testing.T, maxAge int) http.Handler {
+	t.Helper()
+
+	registry := control.Registry{Keys: []control.RegistryEntry{{
+		ID:            "signer",
+		Hash:          control.HashSecret("signer-secret", "signer-salt"),
+		Salt:          "signer-salt",
+		SigningSecret: signerSecret,
+	}}}
+	registryFile := filepath.Join(t.TempDir(), "keys.toml")
+	if err := registry.Write(registryFile); err != nil {
+		t.Fatal(err)
+	}
+	ks, err := control.InitializeKeystore("", registryFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var state atomic.Pointer[server.ScopedMiddlewareParams]
+	state.Store(&server.ScopedMiddlewareParams{
+		LangMap:      server.LangMap{"1": {Entry: "python", Ext: "py", Run: "python"}},
+		MaxBytesSize: 1 << 20,
+		KeyStore:     ks,
+		ReplayCache:  control.NewReplayCache(maxAge),
+		Executor:     fake.NewExecutor(),
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /run", server.ScopedMiddleware(Run, &state))
+	return server.Middleware(mux, server.MiddlewareParams{Draining: &atomic.Bool{}})
+}
+
+/**
+ * Returns headers that sign a request to /run with the
+ * signer key.
+ *
+ * @param body string Request body
+ * @param nonce string Nonce
+ * @param secret string Signing secret
+ * @param timestamp time.Time Time of the request
+ * @return map[string]string Headers
+ */
+func signedHeader(body, nonce, secret string, timestamp time.Time) map[string]string {
+	unix := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(control.SigningString(http.MethodPost, "/run", "signer", unix, nonce, []byte(body)))
+
+	return map[string]string{
+		"X-Key-ID":    "signer",
+		"X-Timestamp": unix,
+		"X-Nonce":     nonce,
+		"X-Signature": hex.EncodeToString(mac.Sum(nil)),
+	}
+}
+
+func TestRunSigned(t *testing.T) {
+	handler := newSignedHandler(t, 300)
+	body := runBody("1", "")
+	now := time.Now()
+
+	if w := postRun(handler, body, signedHeader(body, "nonce-nonce-nonce-1", signerSecret, now)); w.Code != http.StatusOK {
+		t.Fatalf("valid signature: status %d, want 200: %s", w.Code, w.Body.String())
+	}
+
+	tests := map[string]struct {
+		body   string
+		header map[string]string
+	}{
+		"replayed nonce":  {body, signedHeader(body, "nonce-nonce-nonce-1", signerSecret, now)},
+		"wrong secret":    {body, signedHeader(body, "nonce-nonce-nonce-2", "wrong-secret-wrong-secret-wrong!", now)},
+		"tampered body":   {runBody("1", `"stdin": "x"`), signedHeader(body, "nonce-nonce-nonce-3", signerSecret, now)},
+		"stale timestamp": {body, signedHeader(body, "nonce-nonce-nonce-4", signerSecret, now.Add(-time.Hour))},
+		"short nonce":     {body, signedHeader(body, "nonce", signerSecret, now)},
+	}
+	for name, test := range tests {
+		if w := postRun(handler, test.body, test.header); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status %d, want 401", name, w.Code)
+		}
+	}
+
+	disabled := newSignedHandler(t, 0)
+	if w := postRun(disabled, body, signedHeader(body, "nonce-nonce-nonce-5", signerSecret, now)); w.Code != http.StatusUnauthorized {
+		t.Errorf("signing disabled: status %d, want 401", w.Code)
+	}
+}
@@ -0,0 +1,72 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package routes
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"whipcode/sandbox"
+)
+
+func TestDecodeSource(t *testing.T) {
+	content := base64.StdEncoding.EncodeToString([]byte("x"))
+	project := func(paths ...string) User {
+		user := User{}
+		for _, path := range paths {
+			user.Files = append(user.Files, File{Path: path, Content: content})
+		}
+		return user
+	}
+
+	files, entryPoint, detail := decodeSource(User{Code: content}, "py")
+	if detail != "" || entryPoint != "source.py" || len(files) != 1 || files[0] != (sandbox.SourceFile{Path: "source.py", Content: "x"}) {
+		t.Errorf("decodeSource(code) = %v, %q, %q", files, entryPoint, detail)
+	}
+
+	valid := []User{
+		project("main.py"),
+		project("main.py", "lib/util.py", "lib/more/deep.py"),
+		project("main.py", "lib.py", "lib/util.py"),
+	}
+	for _, user := range valid {
+		if _, entryPoint, detail := decodeSource(user, "py"); detail != "" || entryPoint != "main.py" {
+			t.Errorf("decodeSource(%v) = %q, %q, want main.py", user.Files, entryPoint, detail)
+		}
+	}
+
+	invalid := []User{
+		{},
+		{Code: "not base64"},
+		{Code: content, Files: project("main.py").Files},
+		project("lib.py"),
+		project("main.py", "main.py"),
+		project("main.py", "../main.py"),
+		project("main.py", "/etc/passwd"),
+		project("main.py", ".hidden"),
+		project("main.py", "lib//util.py"),
+		project("main.py", "lib", "lib/util.py"),
+		project("main.py", "lib/util.py", "lib"),
+		project("main.py", "a/b", "a/b/c/d.py"),
+		project("main.py", "main.py/x.py"),
+	}
+	for _, user := range invalid {
+		if files, _, detail := decodeSource(user, "py"); detail == "" {
+			t.Errorf("decodeSource(%v) = %v, want an error", user.Files, files)
+		}
+	}
+}
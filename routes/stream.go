@@ -0,0 +1,110 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+
+	"whipcode/sandbox"
+	"whipcode/server"
+)
+
+/**
+ * Streaming endpoint that accepts the same body as /run,
+ * but replies with Server-Sent Events. Output is sent as
+ * stdout and stderr events while it is produced, followed
+ * by a result event with the same body /run would reply
+ * with, or an error event. The stream is only started
+ * with the first event, so executions that are rejected
+ * before producing output get the same response as /run.
+ *
+ * @param w http.ResponseWriter Response writer
+ * @param r *http.Request Request object
+ */
+func Stream(w http.ResponseWriter, r *http.Request) {
+	r, ok := authorize(w, r)
+	if !ok {
+		return
+	}
+
+	executionOptions, ok := parseExecution(w, r)
+	if !ok {
+		return
+	}
+
+	if len(executionOptions.TestCases) > 0 {
+		badRequest(w, "parameter test_cases is not supported when streaming")
+		return
+	}
+
+	rc := http.NewResponseController(w)
+
+	var mu sync.Mutex
+	started := false
+	send := func(event string, data interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if !started {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+
+		dataBytes, _ := json.Marshal(data)
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, dataBytes); err != nil {
+			log.Debug("Failed to write event", "Error", err)
+			return
+		}
+		rc.Flush()
+	}
+
+	executionOptions.EnableCache = false
+	executionOptions.OnOutput = func(phase, stream string, chunk []byte) {
+		send(stream, map[string]string{
+			"phase":     phase,
+			"data":      string(chunk),
+			"timestamp": time.Now().UTC().Format(time.RFC3339Nano),
+		})
+	}
+
+	ex, _ := r.Context().Value(server.ExecutorContextKey).(sandbox.Executor)
+
+	result := ex.Run(r.Context(), executionOptions)
+	recordResult(r, result)
+	if result.Status != http.StatusOK {
+		mu.Lock()
+		streaming := started
+		mu.Unlock()
+
+		if !streaming {
+			sendResult(w, ex, result)
+			return
+		}
+		send("error", result.Body)
+		return
+	}
+
+	send("result", result.Body)
+}
@@ -0,0 +1,152 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package routes
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"whipcode/control"
+	"whipcode/fake"
+	"whipcode/sandbox"
+	"whipcode/server"
+)
+
+/**
+ * Creates the handler for /run/stream, backed by a fake
+ * executor and authorized with the master key "secret".
+ *
+ * @param t *testing.T Test
+ * @return http.Handler Handler
+ * @return *fake.Executor Fake executor
+ */
+func newStreamHandler(t *testing.T) (http.Handler, *fake.Executor) {
+	t.Helper()
+
+	keyFile := filepath.Join(t.TempDir(), ".masterkey")
+	if err := os.WriteFile(keyFile, []byte(control.HashSecret("secret", "salt")+"\nsalt"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ks, err := control.InitializeKeystore(keyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	executor := fake.NewExecutor()
+	var state atomic.Pointer[server.ScopedMiddlewareParams]
+	state.Store(&server.ScopedMiddlewareParams{
+		LangMap:      server.LangMap{"1": {Entry: "python", Ext: "py", Run: "python"}},
+		MaxBytesSize: 1 << 20,
+		MaxTestCases: 4,
+		KeyStore:     ks,
+		Executor:     executor,
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /run/stream", server.ScopedMiddleware(Stream, &state))
+	return server.Middleware(mux, server.MiddlewareParams{Draining: &atomic.Bool{}}), executor
+}
+
+/**
+ * Sends a streaming request that runs print(1) to the
+ * handler.
+ *
+ * @param handler http.Handler Handler
+ * @param extra string Extra JSON fields, may be empty
+ * @return *httptest.ResponseRecorder Response
+ */
+func postStream(handler http.Handler, extra string) *httptest.ResponseRecorder {
+	body := `{"language_id": 1, "code": "` + base64.StdEncoding.EncodeToString([]byte("print(1)")) + `"`
+	if extra != "" {
+		body += ", " + extra
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/run/stream", bytes.NewBufferString(body+"}"))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("X-Master-Key", "secret")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestStream(t *testing.T) {
+	handler, _ := newStreamHandler(t)
+
+	w := postStream(handler, `"stdin": "hello"`)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status %d, Content-Type %q, want an event stream", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	events := w.Body.String()
+	stdout := strings.Index(events, "event: stdout\ndata: ")
+	result := strings.Index(events, "event: result\ndata: ")
+	if stdout < 0 || result < stdout || !strings.Contains(events, `"data":"hello"`) {
+		t.Errorf("unexpected events %q", events)
+	}
+}
+
+func TestStreamRejected(t *testing.T) {
+	handler, executor := newStreamHandler(t)
+	executor.Handler = func(ctx context.Context, opt sandbox.ExecutionOptions) sandbox.Result {
+		return sandbox.Result{Status: http.StatusServiceUnavailable, Body: map[string]interface{}{"detail": "server is busy"}}
+	}
+
+	w := postStream(handler, "")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("status %d, Retry-After %q, want 503 with Retry-After", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Content-Type %q, want application/json", w.Header().Get("Content-Type"))
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body["detail"] != "server is busy" {
+		t.Errorf("unexpected body %q", w.Body.String())
+	}
+}
+
+func TestStreamFailedAfterOutput(t *testing.T) {
+	handler, executor := newStreamHandler(t)
+	executor.Handler = func(ctx context.Context, opt sandbox.ExecutionOptions) sandbox.Result {
+		opt.OnOutput("run", "stdout", []byte("partial"))
+		return sandbox.Result{Status: http.StatusInternalServerError, Body: map[string]interface{}{"detail": "execution failed"}}
+	}
+
+	w := postStream(handler, "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "event: error\ndata: ") {
+		t.Errorf("status %d, body %q, want an error event", w.Code, w.Body.String())
+	}
+}
+
+func TestStreamTestCases(t *testing.T) {
+	handler, _ := newStreamHandler(t)
+
+	w := postStream(handler, `"test_cases": [{"stdin": "1", "expected_stdout": "1"}]`)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400", w.Code)
+	}
+}
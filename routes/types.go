@@ -20,17 +20,78 @@
  * Struct for decoding requests to the /run endpoint.
  *
  * @field Code string Code to run
+ * @field Files []File Project files, used instead of Code
+ * @field EntryPoint string Project file to run
  * @field LanguageID StrInt ID of the language
  * @field Args string Compiler/interpreter arguments
  * @field Timeout StrInt Execution timeout
+ * @field Stdin string Standard input
+ * @field StdinB64 string Standard input, base64 encoded
+ * @field Env map[string]string Environment variables
+ * @field TestCases []TestCase Test cases to run
+ * @field CompareMode string Output comparison mode
+ * @field FloatTolerance *float64 Tolerance for float mode,
+ *   nil if not sent
+ * @field MemoryLimit StrInt Requested memory limit
+ * @field CPULimit StrInt Requested number of CPUs
+ * @field PidsLimit StrInt Requested process limit
+ * @field MaxOutputBytes StrInt Requested output limit
  */
 type User struct {
-	Code       string            `json:"code"`
-	LanguageID StrInt            `json:"language_id"`
-	Args       string            `json:"args"`
-	Timeout    StrInt            `json:"timeout"`
-	Stdin      string            `json:"stdin"`
-	Env        map[string]string `json:"env"`
+	Code           string            `json:"code"`
+	Files          []File            `json:"files"`
+	EntryPoint     string            `json:"entrypoint"`
+	LanguageID     StrInt            `json:"language_id"`
+	Args           string            `json:"args"`
+	Timeout        StrInt            `json:"timeout"`
+	Stdin          string            `json:"stdin"`
+	StdinB64       string            `json:"stdin_b64"`
+	Env            map[string]string `json:"env"`
+	TestCases      []TestCase        `json:"test_cases"`
+	CompareMode    string            `json:"compare_mode"`
+	FloatTolerance *float64          `json:"float_tolerance"`
+	MemoryLimit    StrInt            `json:"memory_limit"`
+	CPULimit       StrInt            `json:"cpu_limit"`
+	PidsLimit      StrInt            `json:"pids_limit"`
+	MaxOutputBytes StrInt            `json:"max_output_bytes"`
+}
+
+/**
+ * Struct for decoding a single test case.
+ *
+ * @field Stdin string Standard input
+ * @field StdinB64 string Standard input, base64 encoded
+ * @field ExpectedStdout string Expected standard output
+ * @field Timeout StrInt Timeout for this case
+ */
+type TestCase struct {
+	Stdin          string `json:"stdin"`
+	StdinB64       string `json:"stdin_b64"`
+	ExpectedStdout string `json:"expected_stdout"`
+	Timeout        StrInt `json:"timeout"`
+}
+
+/**
+ * Struct for decoding a single project file.
+ *
+ * @field Path string Path relative to the project root
+ * @field Content string File content, base64 encoded
+ */
+type File struct {
+	Path    string `json:"path"`
+	Content string `json:"content"`
+}
+
+/**
+ * Struct for decoding messages sent by the client during
+ * an interactive session.
+ *
+ * @field Type string Message type, stdin or eof
+ * @field Data string Data written to stdin
+ */
+type SessionMessage struct {
+	Type string `json:"type"`
+	Data string `json:"data"`
 }
 
 /**
@@ -0,0 +1,89 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package sandbox
+
+import "io"
+
+/**
+ * Wraps the given writer so that no more than limit bytes
+ * of a stream reach it, not counting the start marker in
+ * front of the stream. A limit of zero means no limit.
+ *
+ * @param w io.Writer Underlying writer
+ * @param stream string Name of the stream
+ * @param limit int64 Max bytes of output to pass on
+ * @param onExceed func() Called once when the limit is
+ *   exceeded, may be nil
+ * @return *limitWriter Limited writer
+ */
+func newLimitWriter(w io.Writer, stream string, limit int64, onExceed func()) *limitWriter {
+	marker := int64(len(stream + "-start\n"))
+	if limit > 0 {
+		limit += marker
+	}
+
+	return &limitWriter{
+		w:        w,
+		limit:    limit,
+		marker:   marker,
+		onExceed: onExceed,
+	}
+}
+
+/**
+ * Writes as much of the chunk as the limit allows and
+ * counts the rest. Always reports the whole chunk as
+ * written so that the process producing the output is
+ * never blocked or failed.
+ *
+ * @param p []byte Chunk of output
+ * @return int Number of bytes consumed
+ * @return error Error from the underlying writer
+ */
+func (lw *limitWriter) Write(p []byte) (int, error) {
+	chunk := p
+	if lw.limit > 0 {
+		remaining := max(lw.limit-lw.written, 0)
+		if int64(len(chunk)) > remaining {
+			chunk = chunk[:remaining]
+			if !lw.exceeded {
+				lw.exceeded = true
+				if lw.onExceed != nil {
+					lw.onExceed()
+				}
+			}
+		}
+	}
+	lw.written += int64(len(p))
+
+	if len(chunk) > 0 {
+		if _, err := lw.w.Write(chunk); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+/**
+ * Returns the number of bytes the stream produced, kept
+ * or not, without the start marker.
+ *
+ * @return int64 Total bytes
+ */
+func (lw *limitWriter) total() int64 {
+	return max(lw.written-lw.marker, 0)
+}
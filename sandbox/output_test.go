@@ -0,0 +1,67 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package sandbox
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestLimitWriter(t *testing.T) {
+	tests := []struct {
+		name     string
+		limit    int64
+		chunks   []string
+		want     string
+		exceeded bool
+		total    int64
+	}{
+		{"no limit", 0, []string{"stdout-start\n", "hello ", "world"}, "stdout-start\nhello world", false, 11},
+		{"under the limit", 20, []string{"stdout-start\nhello"}, "stdout-start\nhello", false, 5},
+		{"at the limit", 5, []string{"stdout-start\n", "hello"}, "stdout-start\nhello", false, 5},
+		{"over the limit", 5, []string{"stdout-start\n", "hel", "lo world"}, "stdout-start\nhello", true, 11},
+		{"past the limit", 2, []string{"stdout-start\nab", "cd", "ef"}, "stdout-start\nab", true, 6},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			calls := 0
+			lw := newLimitWriter(&out, "stdout", test.limit, func() { calls++ })
+
+			for _, chunk := range test.chunks {
+				n, err := lw.Write([]byte(chunk))
+				if err != nil || n != len(chunk) {
+					t.Fatalf("Write(%q) = %d, %v, want %d, nil", chunk, n, err, len(chunk))
+				}
+			}
+
+			if out.String() != test.want {
+				t.Errorf("output = %q, want %q", out.String(), test.want)
+			}
+			if lw.exceeded != test.exceeded {
+				t.Errorf("exceeded = %t, want %t", lw.exceeded, test.exceeded)
+			}
+			if wantCalls := map[bool]int{false: 0, true: 1}[test.exceeded]; calls != wantCalls {
+				t.Errorf("onExceed called %d times, want %d", calls, wantCalls)
+			}
+			if lw.total() != test.total {
+				t.Errorf("total = %d, want %d", lw.total(), test.total)
+			}
+		})
+	}
+}
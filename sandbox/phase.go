@@ -0,0 +1,145 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"whipcode/metrics"
+)
+
+/**
+ * Runs a shell command inside a sandbox and captures its
+ * output. The command's output is prefixed with markers
+ * to catch anything the runtime itself writes.
+ * Output past the phase's limit is counted but discarded,
+ * and the processes of the phase are killed if the
+ * executor is configured to. They are always killed when
+ * the phase times out, since killing the command only
+ * stops the runtime's client.
+ * If the phase has a stdin reader, it is copied byte for
+ * byte into the command until it is exhausted or the
+ * command exits.
+ *
+ * @param parent context.Context Context of the execution
+ * @param b Box Sandbox to run in
+ * @param spec phaseSpec Phase to run
+ * @return phaseResult Result of the phase
+ * @return error Error object, set on unsafe output or
+ *   if the execution was cancelled
+ */
+func (ex *Engine) execPhase(parent context.Context, b Box, spec phaseSpec) (phaseResult, error) {
+	ctx, cancel := context.WithTimeout(parent, time.Duration(spec.timeout)*time.Second)
+	defer cancel()
+
+	oomBefore := b.OOMKills()
+
+	var stdout, stderr bytes.Buffer
+	var stdoutDest, stderrDest io.Writer = &stdout, &stderr
+
+	if spec.onOutput != nil {
+		stdoutStream := newStreamWriter(spec.name, "stdout", spec.onOutput)
+		stderrStream := newStreamWriter(spec.name, "stderr", spec.onOutput)
+		defer stdoutStream.flush()
+		defer stderrStream.flush()
+		stdoutDest = io.MultiWriter(&stdout, stdoutStream)
+		stderrDest = io.MultiWriter(&stderr, stderrStream)
+	}
+
+	var onExceed func()
+	if ex.killOnOutputLimit {
+		var once sync.Once
+		onExceed = func() {
+			once.Do(func() { go b.KillAll() })
+		}
+	}
+
+	stdoutLimit := newLimitWriter(stdoutDest, "stdout", spec.maxOutput, onExceed)
+	stderrLimit := newLimitWriter(stderrDest, "stderr", spec.maxOutput, onExceed)
+
+	cmdExec := b.Command(ctx, spec.stdin != nil, "echo stdout-start && echo stderr-start >&2 && "+spec.command)
+	cmdExec.Stdout = stdoutLimit
+	cmdExec.Stderr = stderrLimit
+	cmdExec.WaitDelay = time.Second
+
+	var stdinPipe io.WriteCloser
+	if spec.stdin != nil {
+		var err error
+		if stdinPipe, err = cmdExec.StdinPipe(); err != nil {
+			return phaseResult{}, err
+		}
+	}
+
+	startTime := time.Now()
+	if err := cmdExec.Start(); err != nil {
+		return phaseResult{}, err
+	}
+	if stdinPipe != nil {
+		go func() {
+			io.Copy(stdinPipe, spec.stdin)
+			stdinPipe.Close()
+		}()
+	}
+	cmdExec.Wait()
+	duration := time.Since(startTime).Seconds()
+
+	if err := parent.Err(); err != nil {
+		return phaseResult{}, fmt.Errorf("%s phase: %w", spec.name, err)
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		b.KillAll()
+		metrics.Timeouts.WithLabelValues(spec.language).Inc()
+		return phaseResult{
+			duration:        duration,
+			timeout:         true,
+			stdoutTruncated: stdoutLimit.exceeded,
+			stderrTruncated: stderrLimit.exceeded,
+			stdoutBytes:     stdoutLimit.total(),
+			stderrBytes:     stderrLimit.total(),
+		}, nil
+	}
+
+	stdoutStr := stdout.String()
+	stderrStr := stderr.String()
+	if !strings.HasPrefix(stdoutStr, "stdout-start") || !strings.HasPrefix(stderrStr, "stderr-start") {
+		return phaseResult{}, fmt.Errorf("unsafe output: %q %q", stdoutStr, stderrStr)
+	}
+
+	oomKilled := b.OOMKills() > oomBefore
+	if oomKilled {
+		metrics.OOMKills.WithLabelValues(spec.language).Inc()
+	}
+
+	return phaseResult{
+		stdout:          strings.TrimPrefix(stdoutStr, "stdout-start\n"),
+		stderr:          strings.TrimPrefix(stderrStr, "stderr-start\n"),
+		exitCode:        cmdExec.ProcessState.ExitCode(),
+		oomKilled:       oomKilled,
+		duration:        duration,
+		stdoutTruncated: stdoutLimit.exceeded,
+		stderrTruncated: stderrLimit.exceeded,
+		stdoutBytes:     stdoutLimit.total(),
+		stderrBytes:     stderrLimit.total(),
+	}, nil
+}
@@ -0,0 +1,138 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package sandbox
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	errQueueFull    = errors.New("execution queue is full")
+	errQueueTimeout = errors.New("timed out waiting in the execution queue")
+)
+
+/**
+ * Creates a new admission queue. Executions beyond the
+ * concurrency limit wait in FIFO order.
+ *
+ * @param maxRunning int Max concurrent executions, zero
+ *   for no limit
+ * @param maxQueued int Max executions waiting for a slot
+ * @param timeout int Max seconds to wait for a slot
+ * @return *Queue Queue object
+ */
+func NewQueue(maxRunning, maxQueued, timeout int) *Queue {
+	return &Queue{
+		maxRunning: maxRunning,
+		maxQueued:  maxQueued,
+		timeout:    time.Duration(timeout) * time.Second,
+		waiters:    list.New(),
+	}
+}
+
+/**
+ * Waits for an execution slot. Every successful call must
+ * be paired with a call to Release.
+ *
+ * @param ctx context.Context Context of the execution
+ * @return int Position in the queue on arrival, zero if a
+ *   slot was free
+ * @return time.Duration Time spent waiting
+ * @return error Error object, set if the queue is full,
+ *   the wait timed out or the context was cancelled
+ */
+func (q *Queue) Acquire(ctx context.Context) (int, time.Duration, error) {
+	if q.maxRunning <= 0 {
+		return 0, 0, nil
+	}
+
+	q.mu.Lock()
+	if q.running < q.maxRunning && q.waiters.Len() == 0 {
+		q.running++
+		q.mu.Unlock()
+		return 0, 0, nil
+	}
+	if q.waiters.Len() >= q.maxQueued {
+		q.mu.Unlock()
+		return 0, 0, errQueueFull
+	}
+	ready := make(chan struct{})
+	element := q.waiters.PushBack(ready)
+	position := q.waiters.Len()
+	q.mu.Unlock()
+
+	startTime := time.Now()
+	timer := time.NewTimer(q.timeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-ready:
+		return position, time.Since(startTime), nil
+	case <-timer.C:
+		err = errQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	q.mu.Lock()
+	select {
+	case <-ready:
+		q.mu.Unlock()
+		q.Release()
+	default:
+		q.waiters.Remove(element)
+		q.mu.Unlock()
+	}
+
+	return 0, time.Since(startTime), err
+}
+
+/**
+ * Frees an execution slot, handing it straight to the
+ * first waiter if there is one.
+ */
+func (q *Queue) Release() {
+	if q.maxRunning <= 0 {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if front := q.waiters.Front(); front != nil {
+		q.waiters.Remove(front)
+		close(front.Value.(chan struct{}))
+		return
+	}
+	q.running--
+}
+
+/**
+ * Returns the number of running and waiting executions.
+ *
+ * @return int Running executions
+ * @return int Waiting executions
+ */
+func (q *Queue) Stats() (int, int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.running, q.waiters.Len()
+}
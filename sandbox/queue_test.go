@@ -0,0 +1,136 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQueueNoLimit(t *testing.T) {
+	q := NewQueue(0, 0, 1)
+	for range 100 {
+		if _, _, err := q.Acquire(context.Background()); err != nil {
+			t.Fatalf("Acquire: %v", err)
+		}
+	}
+}
+
+func TestQueueFIFO(t *testing.T) {
+	q := NewQueue(1, 3, 5)
+	if position, _, err := q.Acquire(context.Background()); err != nil || position != 0 {
+		t.Fatalf("Acquire = %d, %v, want a free slot", position, err)
+	}
+
+	order := make(chan int, 3)
+	for i := 1; i <= 3; i++ {
+		go func() {
+			position, _, err := q.Acquire(context.Background())
+			if err != nil || position != i {
+				t.Errorf("waiter %d: Acquire = %d, %v", i, position, err)
+			}
+			order <- i
+		}()
+		waitFor(t, func() bool {
+			_, waiting := q.Stats()
+			return waiting == i
+		})
+	}
+
+	if _, _, err := q.Acquire(context.Background()); !errors.Is(err, errQueueFull) {
+		t.Errorf("Acquire on a full queue = %v, want %v", err, errQueueFull)
+	}
+
+	for want := 1; want <= 3; want++ {
+		q.Release()
+		if got := <-order; got != want {
+			t.Fatalf("slot went to waiter %d, want %d", got, want)
+		}
+	}
+
+	q.Release()
+	if running, waiting := q.Stats(); running != 0 || waiting != 0 {
+		t.Errorf("Stats = %d, %d after releasing every slot, want 0, 0", running, waiting)
+	}
+}
+
+func TestQueueTimeout(t *testing.T) {
+	q := NewQueue(1, 1, 1)
+	q.Acquire(context.Background())
+
+	_, waited, err := q.Acquire(context.Background())
+	if !errors.Is(err, errQueueTimeout) {
+		t.Fatalf("Acquire = %v, want %v", err, errQueueTimeout)
+	}
+	if waited < time.Second {
+		t.Errorf("waited %s, want at least 1s", waited)
+	}
+	if _, waiting := q.Stats(); waiting != 0 {
+		t.Errorf("%d waiters left after the timeout, want 0", waiting)
+	}
+
+	q.Release()
+	if running, _ := q.Stats(); running != 0 {
+		t.Errorf("%d slots held after release, want 0", running)
+	}
+}
+
+func TestQueueCancel(t *testing.T) {
+	q := NewQueue(1, 1, 5)
+	q.Acquire(context.Background())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, _, err := q.Acquire(ctx)
+		done <- err
+	}()
+	waitFor(t, func() bool {
+		_, waiting := q.Stats()
+		return waiting == 1
+	})
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Acquire = %v, want %v", err, context.Canceled)
+	}
+
+	q.Release()
+	if running, waiting := q.Stats(); running != 0 || waiting != 0 {
+		t.Errorf("Stats = %d, %d, want 0, 0", running, waiting)
+	}
+}
+
+/**
+ * Polls the condition until it holds, failing the test
+ * if it doesn't within a second.
+ *
+ * @param t *testing.T Test
+ * @param condition func() bool Condition to wait for
+ */
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met within 1s")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,725 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package sandbox
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"whipcode/cache"
+	"whipcode/metrics"
+
+	"github.com/charmbracelet/log"
+	"golang.org/x/sys/unix"
+)
+
+/**
+ * Status code for executions cancelled by the caller,
+ * borrowed from nginx's "client closed request".
+ */
+const StatusCancelled = 499
+
+/**
+ * Smallest memory limit a sandbox can be given, the
+ * minimum podman accepts.
+ */
+const MinMemory = 6 << 20
+
+/**
+ * Creates a new Engine instance on top of the given
+ * runtime.
+ *
+ * @param runtime Runtime Sandbox backend
+ * @param timeout int Timeout for the run phase
+ * @param compileTimeout int Timeout for the compile phase
+ * @param batchTimeout int Total timeout for all test cases
+ * @param killOnOutputLimit bool Kill phases that exceed
+ *   the output limit
+ * @param queue *Queue Admission queue for executions
+ * @param store cache.Store Store for cached results, nil
+ *   if caching is disabled
+ * @return *Engine New Engine instance
+ */
+func NewEngine(runtime Runtime, timeout, compileTimeout, batchTimeout int, killOnOutputLimit bool, queue *Queue, store cache.Store) *Engine {
+	return &Engine{
+		runtime:           runtime,
+		store:             store,
+		timeout:           timeout,
+		compileTimeout:    compileTimeout,
+		batchTimeout:      batchTimeout,
+		killOnOutputLimit: killOnOutputLimit,
+		queue:             queue,
+	}
+}
+
+/**
+ * Cleans up the temp directory. Called on SIGINT and
+ * SIGTERM.
+ */
+func Cleanup() {
+	if err := os.RemoveAll(filepath.Join(".", "run")); err != nil {
+		log.Error("Could not clean up temp dir", "Error", err)
+	}
+}
+
+/**
+ * Returns the ID to name a sandbox after. The request ID
+ * is used as is, unless the sandbox of an earlier request
+ * with the same client supplied ID is still around, in
+ * which case a random suffix is added. Sandboxes without
+ * a request get a random ID.
+ *
+ * @param requestID string ID of the request, may be empty
+ * @return string Sandbox ID
+ */
+func BoxID(requestID string) string {
+	suffix := strconv.Itoa(rand.Intn(9000000) + 1000000)
+	if requestID == "" {
+		return suffix
+	}
+
+	if _, err := os.Stat(filepath.Join(".", "run", "run"+requestID)); err == nil {
+		return requestID + "-" + suffix
+	}
+	return requestID
+}
+
+/**
+ * Sanitizes the given string from shell injection.
+ *
+ * @param String string Code to sanitize
+ * @return string Sanitized code
+ */
+func Sanitize(String string) string {
+	slices := strings.Fields(String)
+	for i, slice := range slices {
+		slices[i] = "'" + strings.ReplaceAll(slice, "'", "'\\''") + "'"
+	}
+	return strings.Join(slices, " ")
+}
+
+/**
+ * Parses a size like "512m" into bytes. The suffix is
+ * optional and may be k, m or g, case insensitive.
+ *
+ * @param size string Size to parse
+ * @return int64 Size in bytes
+ * @return error Error object
+ */
+func ParseSize(size string) (int64, error) {
+	size = strings.ToLower(strings.TrimSpace(size))
+
+	unit := int64(1)
+	switch {
+	case strings.HasSuffix(size, "k"):
+		unit = 1 << 10
+	case strings.HasSuffix(size, "m"):
+		unit = 1 << 20
+	case strings.HasSuffix(size, "g"):
+		unit = 1 << 30
+	}
+	if unit > 1 {
+		size = size[:len(size)-1]
+	}
+
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || n <= 0 || n > math.MaxInt64/unit {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+
+	return n * unit, nil
+}
+
+/**
+ * Creates the given project directory and writes the
+ * submitted files into it. Fails if the directory already
+ * exists.
+ *
+ * @param dir string Project directory
+ * @param files []SourceFile Files to write
+ * @return error Error object
+ */
+func WriteProject(dir string, files []SourceFile) error {
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return err
+	}
+
+	return WriteFiles(dir, files)
+}
+
+/**
+ * Writes the submitted files into an existing project
+ * directory, creating any parent directories.
+ *
+ * @param dir string Project directory
+ * @param files []SourceFile Files to write
+ * @return error Error object
+ */
+func WriteFiles(dir string, files []SourceFile) error {
+	for _, file := range files {
+		path := filepath.Join(dir, file.Path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(file.Content), 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/**
+ * Returns a digest of everything outside the request that
+ * can change the result of an execution: the executor's
+ * timeouts and output setting, and the entry and compile
+ * scripts of the language, which are read from disk every
+ * time so that edits to them take effect right away.
+ *
+ * @param opt ExecutionOptions Execution options
+ * @return string Hex encoded digest
+ */
+func (ex *Engine) configDigest(opt ExecutionOptions) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%d %d %t\n", ex.compileTimeout, ex.batchTimeout, ex.killOnOutputLimit)
+
+	for _, script := range []string{opt.Run, opt.Compile} {
+		if script == "" {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(".", "entry", script+".sh"))
+		if err != nil {
+			log.Debug("Could not read script", "Script", script, "Error", err)
+		}
+		fmt.Fprintf(hash, "%s %d:%s\n", script, len(content), content)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+/**
+ * Builds the cache key for the given options, a SHA-256
+ * hash over everything that can change the result of an
+ * execution. Every field is length prefixed so that
+ * different inputs never run together into the same key.
+ *
+ * @param opt ExecutionOptions Execution options
+ * @param cArgs string Sanitized args
+ * @param imageID string ID of the image the execution
+ *   runs in
+ * @param config string Digest of the executor's settings
+ *   and scripts, see configDigest
+ * @param timeout int Timeout of the run phase
+ * @return string Hex encoded cache key
+ */
+func cacheKey(opt ExecutionOptions, cArgs, imageID, config string, timeout int) string {
+	hash := sha256.New()
+	field := func(value string) {
+		fmt.Fprintf(hash, "%d:%s", len(value), value)
+	}
+
+	field(imageID)
+	field(config)
+	field(opt.Entry)
+	field(opt.Compile)
+	field(opt.Run)
+	field(opt.EntryPoint)
+	field(cArgs)
+	field(opt.Stdin)
+	field(strconv.Itoa(timeout))
+	field(strconv.Itoa(opt.MaxTimeout))
+	field(strconv.Itoa(opt.KeyMaxTimeout))
+	field(fmt.Sprintf("%d %g %d %d %d", opt.Limits.Memory, opt.Limits.CPUs, opt.Limits.Pids, opt.Limits.Tmpfs, opt.Limits.Output))
+
+	envKeys := make([]string, 0, len(opt.Env))
+	for k := range opt.Env {
+		envKeys = append(envKeys, k)
+	}
+	sort.Strings(envKeys)
+	field(strconv.Itoa(len(envKeys)))
+	for _, k := range envKeys {
+		field(k)
+		field(opt.Env[k])
+	}
+
+	field(strconv.Itoa(len(opt.Files)))
+	for _, file := range opt.Files {
+		field(file.Path)
+		field(file.Content)
+	}
+
+	field(strconv.Itoa(len(opt.TestCases)))
+	for _, testCase := range opt.TestCases {
+		field(strconv.Itoa(testCase.Timeout))
+		field(testCase.Stdin)
+		field(testCase.ExpectedStdout)
+	}
+	field(opt.CompareMode)
+	field(strconv.FormatFloat(opt.FloatTolerance, 'g', -1, 64))
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+/**
+ * Returns the name of the signal that terminated the
+ * process, derived from the shell's 128+n exit status.
+ *
+ * @param exitCode int Exit code of the process
+ * @return interface{} Signal name, or nil if not signaled
+ */
+func signalName(exitCode int) interface{} {
+	if exitCode <= 128 || exitCode > 128+64 {
+		return nil
+	}
+
+	if name := unix.SignalName(syscall.Signal(exitCode - 128)); name != "" {
+		return name
+	}
+
+	return nil
+}
+
+/**
+ * Converts the result of a phase into its response
+ * body. Timed out phases have no output or exit code,
+ * but still report how much output they produced.
+ *
+ * @return map[string]interface{} Phase result
+ */
+func (p phaseResult) toMap() map[string]interface{} {
+	if p.timeout {
+		return map[string]interface{}{
+			"stdout":           "",
+			"stderr":           "",
+			"exit_code":        nil,
+			"signal":           nil,
+			"oom_killed":       p.oomKilled,
+			"timeout":          true,
+			"duration":         p.duration,
+			"stdout_truncated": p.stdoutTruncated,
+			"stderr_truncated": p.stderrTruncated,
+			"stdout_bytes":     p.stdoutBytes,
+			"stderr_bytes":     p.stderrBytes,
+		}
+	}
+
+	return map[string]interface{}{
+		"stdout":           p.stdout,
+		"stderr":           p.stderr,
+		"exit_code":        p.exitCode,
+		"signal":           signalName(p.exitCode),
+		"oom_killed":       p.oomKilled,
+		"timeout":          false,
+		"duration":         p.duration,
+		"stdout_truncated": p.stdoutTruncated,
+		"stderr_truncated": p.stderrTruncated,
+		"stdout_bytes":     p.stdoutBytes,
+		"stderr_bytes":     p.stderrBytes,
+	}
+}
+
+/**
+ * Merges the phases that ran into the top level fields
+ * of the response body, keeping it compatible with
+ * single phase executions.
+ *
+ * @param phases []phaseResult Phases that ran, in order
+ * @param age float64 Total lifetime of the container
+ * @return map[string]interface{} Response body
+ */
+func summarize(phases []phaseResult, age float64) map[string]interface{} {
+	last := phases[len(phases)-1].toMap()
+	result := map[string]interface{}{
+		"stdout":           "",
+		"stderr":           "",
+		"container_age":    age,
+		"timeout":          last["timeout"],
+		"exit_code":        last["exit_code"],
+		"signal":           last["signal"],
+		"oom_killed":       false,
+		"stdout_truncated": false,
+		"stderr_truncated": false,
+		"stdout_bytes":     int64(0),
+		"stderr_bytes":     int64(0),
+	}
+
+	for _, phase := range phases {
+		if phase.oomKilled {
+			result["oom_killed"] = true
+		}
+		if !last["timeout"].(bool) {
+			result["stdout"] = result["stdout"].(string) + phase.stdout
+			result["stderr"] = result["stderr"].(string) + phase.stderr
+		}
+		result["stdout_truncated"] = result["stdout_truncated"].(bool) || phase.stdoutTruncated
+		result["stderr_truncated"] = result["stderr_truncated"].(bool) || phase.stderrTruncated
+		result["stdout_bytes"] = result["stdout_bytes"].(int64) + phase.stdoutBytes
+		result["stderr_bytes"] = result["stderr_bytes"].(int64) + phase.stderrBytes
+	}
+
+	return result
+}
+
+/**
+ * Builds the response for a phase that failed to run,
+ * either because it was cancelled or because of unsafe
+ * output. Phases that run out of time are not errors, so
+ * any context error here means the caller went away, the
+ * client disconnected or the job was cancelled, and is
+ * reported as a cancellation rather than a timeout.
+ *
+ * @param err error Error returned by the phase
+ * @param requestID string ID of the request
+ * @return int HTTP status code
+ * @return map[string]interface{} Response body
+ */
+func phaseError(err error, requestID string) (int, map[string]interface{}) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		log.Info("Execution cancelled", "RequestID", requestID, "Reason", err)
+		return StatusCancelled, map[string]interface{}{
+			"detail":    "execution cancelled",
+			"cancelled": true,
+		}
+	}
+
+	log.Warn("Caught unsafe output", "RequestID", requestID, "Error", err)
+	return http.StatusInternalServerError, map[string]interface{}{
+		"detail": "internal server error",
+	}
+}
+
+/**
+ * Runs the test cases if compilation succeeded and builds
+ * the response body for a batch execution.
+ *
+ * @param ctx context.Context Context of the execution
+ * @param b Box Sandbox to run in
+ * @param opt ExecutionOptions Execution options
+ * @param cArgs string Sanitized args
+ * @param phases []phaseResult Compile phase, if any
+ * @param compiled bool Whether compilation succeeded
+ * @param startTime time.Time Time the container was started
+ * @return int HTTP status code
+ * @return map[string]interface{} Response body
+ */
+func (ex *Engine) testResult(ctx context.Context, b Box, opt ExecutionOptions, cArgs string, phases []phaseResult, compiled bool, startTime time.Time) (int, map[string]interface{}) {
+	cases := make([]map[string]interface{}, len(opt.TestCases))
+	passed := 0
+
+	if compiled {
+		var err error
+		if cases, passed, err = ex.runTestCases(ctx, b, opt, cArgs); err != nil {
+			return phaseError(err, opt.RequestID)
+		}
+	} else {
+		for i := range cases {
+			cases[i] = map[string]interface{}{"verdict": "compile_error"}
+		}
+	}
+
+	result := map[string]interface{}{
+		"test_cases":    cases,
+		"passed":        passed,
+		"total":         len(cases),
+		"container_age": time.Since(startTime).Seconds(),
+	}
+	if len(phases) > 0 {
+		result["compile"] = phases[0].toMap()
+	}
+
+	return http.StatusOK, result
+}
+
+/**
+ * Returns a copy of the response body with the queue
+ * position, wait time and whether it came from the cache
+ * added, so that cached bodies are never modified.
+ *
+ * @param result map[string]interface{} Response body
+ * @param position int Position in the queue on arrival
+ * @param waited time.Duration Time spent in the queue
+ * @param cached bool Whether the result came from the cache
+ * @return map[string]interface{} Response body
+ */
+func withStats(result map[string]interface{}, position int, waited time.Duration, cached bool) map[string]interface{} {
+	withStats := make(map[string]interface{}, len(result)+3)
+	for k, v := range result {
+		withStats[k] = v
+	}
+	withStats["queue_position"] = position
+	withStats["queue_wait"] = waited.Seconds()
+	withStats["cached"] = cached
+	return withStats
+}
+
+/**
+ * Returns the ceiling for the timeout of the run phase and
+ * test cases, the language's or the executor's, lowered to
+ * the key's if it has one.
+ *
+ * @param opt ExecutionOptions Execution options
+ * @return int Timeout in seconds
+ */
+func (ex *Engine) maxTimeout(opt ExecutionOptions) int {
+	maxTimeout := ex.timeout
+	if opt.MaxTimeout > 0 {
+		maxTimeout = opt.MaxTimeout
+	}
+	if opt.KeyMaxTimeout > 0 {
+		maxTimeout = min(maxTimeout, opt.KeyMaxTimeout)
+	}
+	return maxTimeout
+}
+
+/**
+ * Returns the timeout of the run phase, the requested one
+ * capped at the ceiling.
+ *
+ * @param opt ExecutionOptions Execution options
+ * @return int Timeout in seconds
+ */
+func (ex *Engine) runTimeout(opt ExecutionOptions) int {
+	maxTimeout := ex.maxTimeout(opt)
+	if opt.Timeout == 0 || opt.Timeout > maxTimeout {
+		return maxTimeout
+	}
+	return opt.Timeout
+}
+
+/**
+ * Returns the number of seconds clients are told to wait
+ * before retrying when the queue turned them away.
+ *
+ * @return int Seconds to wait
+ */
+func (ex *Engine) RetryAfter() int {
+	return ex.timeout
+}
+
+/**
+ * Stops accepting executions and waits until none are
+ * running, or the deadline is reached.
+ *
+ * @param ctx context.Context Deadline for the wait
+ * @return error Context error if executions were still
+ *   running when the deadline was reached
+ */
+func (ex *Engine) Drain(ctx context.Context) error {
+	ex.draining.Store(true)
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for ex.active.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+/**
+ * Releases the runtime, removing any sandboxes it keeps
+ * around and killing those still in use.
+ */
+func (ex *Engine) Close() {
+	ex.draining.Store(true)
+	ex.runtime.Close()
+}
+
+/**
+ * Runs the given project once a slot in the admission
+ * queue is free. Cached results skip the queue, and
+ * successful results are cached. The response body
+ * reports the position the execution had in the queue,
+ * how long it waited and whether it was cached.
+ *
+ * @param ctx context.Context Context of the execution
+ * @param opt ExecutionOptions Execution options
+ * @return Result Result of the execution
+ */
+func (ex *Engine) Run(ctx context.Context, opt ExecutionOptions) Result {
+	ex.active.Add(1)
+	defer ex.active.Add(-1)
+
+	if ex.draining.Load() {
+		return Result{Status: http.StatusServiceUnavailable, Body: map[string]interface{}{
+			"detail": "server is shutting down",
+		}}
+	}
+
+	status, body := ex.runCode(ctx, opt)
+	return Result{Status: status, Body: body}
+}
+
+/**
+ * Implements Run, returning the status code and body
+ * separately.
+ *
+ * @param ctx context.Context Context of the execution
+ * @param opt ExecutionOptions Execution options
+ * @return int HTTP status code
+ * @return map[string]interface{} Response body
+ */
+func (ex *Engine) runCode(ctx context.Context, opt ExecutionOptions) (int, map[string]interface{}) {
+	cArgs := Sanitize(opt.Args)
+
+	useCache := opt.EnableCache && ex.store != nil
+	var key string
+	if useCache {
+		key = cacheKey(opt, cArgs, ex.runtime.ImageID(opt.Entry), ex.configDigest(opt), ex.runTimeout(opt))
+		if result, found := ex.store.Get(key); found {
+			return http.StatusOK, withStats(result, 0, 0, true)
+		}
+	}
+
+	position, waited, err := ex.queue.Acquire(ctx)
+	if err != nil {
+		if errors.Is(err, errQueueFull) || errors.Is(err, errQueueTimeout) {
+			log.Warn("Execution turned away", "RequestID", opt.RequestID, "Reason", err, "Waited", waited)
+			return http.StatusServiceUnavailable, map[string]interface{}{
+				"detail": err.Error(),
+			}
+		}
+		return phaseError(err, opt.RequestID)
+	}
+	defer ex.queue.Release()
+
+	status, result := ex.execute(ctx, opt, cArgs)
+	if status != http.StatusOK {
+		return status, result
+	}
+
+	if useCache {
+		go ex.store.Set(key, result)
+	}
+
+	return status, withStats(result, position, waited, false)
+}
+
+/**
+ * Runs the given project in a sandbox of the runtime.
+ * Languages with a compile script are compiled first, and
+ * only run if compilation succeeds. If test cases are
+ * given, each of them is run instead of a single run.
+ * Cancelling the context kills the sandbox.
+ *
+ * @param ctx context.Context Context of the execution
+ * @param opt ExecutionOptions Execution options
+ * @param cArgs string Sanitized args
+ * @return int HTTP status code
+ * @return map[string]interface{} Response body
+ */
+func (ex *Engine) execute(ctx context.Context, opt ExecutionOptions, cArgs string) (int, map[string]interface{}) {
+	thisTimeout := ex.runTimeout(opt)
+
+	lifetime := thisTimeout + 1
+	if len(opt.TestCases) > 0 {
+		lifetime = ex.batchTimeout + 1
+	}
+	if opt.Compile != "" {
+		lifetime += ex.compileTimeout
+	}
+
+	startTime := time.Now()
+	b, err := ex.runtime.Start(opt, lifetime)
+	if err != nil {
+		log.Error("Could not start sandbox", "RequestID", opt.RequestID, "Error", err)
+		return http.StatusInternalServerError, map[string]interface{}{
+			"detail": "internal server error",
+		}
+	}
+	defer b.Remove()
+
+	metrics.Containers.Inc()
+	defer metrics.Containers.Dec()
+	defer func() {
+		metrics.ExecutionDuration.WithLabelValues(opt.Entry).Observe(time.Since(startTime).Seconds())
+	}()
+
+	entryPoint := Sanitize(opt.EntryPoint)
+	phases := []phaseResult{}
+	compiled := true
+
+	if opt.Compile != "" {
+		compile, err := ex.execPhase(ctx, b, phaseSpec{
+			name:      "compile",
+			language:  opt.Entry,
+			command:   fmt.Sprintf("sh /compile.sh %s %s </dev/null", entryPoint, cArgs),
+			timeout:   ex.compileTimeout,
+			maxOutput: opt.Limits.Output,
+			onOutput:  opt.OnOutput,
+		})
+		if err != nil {
+			return phaseError(err, opt.RequestID)
+		}
+		phases = append(phases, compile)
+		compiled = !compile.timeout && compile.exitCode == 0
+	}
+
+	if len(opt.TestCases) > 0 {
+		return ex.testResult(ctx, b, opt, cArgs, phases, compiled, startTime)
+	}
+
+	if compiled {
+		var stdin io.Reader = strings.NewReader(opt.Stdin)
+		if opt.StdinReader != nil {
+			stdin = opt.StdinReader
+		}
+
+		run, err := ex.execPhase(ctx, b, phaseSpec{
+			name:      "run",
+			language:  opt.Entry,
+			command:   fmt.Sprintf("sh /entry.sh %s %s", entryPoint, cArgs),
+			timeout:   thisTimeout,
+			stdin:     stdin,
+			maxOutput: opt.Limits.Output,
+			onOutput:  opt.OnOutput,
+		})
+		if err != nil {
+			return phaseError(err, opt.RequestID)
+		}
+		phases = append(phases, run)
+	}
+
+	result := summarize(phases, time.Since(startTime).Seconds())
+	if opt.Compile != "" {
+		result["compile"] = phases[0].toMap()
+		result["run"] = nil
+		if compiled {
+			result["run"] = phases[1].toMap()
+		}
+	}
+
+	return http.StatusOK, result
+}
@@ -0,0 +1,122 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		size string
+		want int64
+	}{
+		{"512", 512},
+		{"1k", 1 << 10},
+		{"64m", 64 << 20},
+		{"2G", 2 << 30},
+		{" 8M ", 8 << 20},
+	}
+	for _, test := range tests {
+		if got, err := ParseSize(test.size); err != nil || got != test.want {
+			t.Errorf("ParseSize(%q) = %d, %v, want %d", test.size, got, err, test.want)
+		}
+	}
+
+	for _, size := range []string{"", "0", "-1m", "m", "1.5g", "1t", "abc", "9999999999999g"} {
+		if got, err := ParseSize(size); err == nil {
+			t.Errorf("ParseSize(%q) = %d, want an error", size, got)
+		}
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	opt := ExecutionOptions{
+		Entry:      "python",
+		Run:        "python",
+		EntryPoint: "main.py",
+		Files:      []SourceFile{{Path: "main.py", Content: "print(1)"}},
+	}
+	base := cacheKey(opt, "", "image", "config", 10)
+
+	if cacheKey(opt, "", "image", "config", 10) != base {
+		t.Error("cache key is not stable")
+	}
+
+	changed := map[string]string{
+		"image":   cacheKey(opt, "", "other", "config", 10),
+		"config":  cacheKey(opt, "", "image", "other", 10),
+		"timeout": cacheKey(opt, "", "image", "config", 5),
+		"args":    cacheKey(opt, "'-O'", "image", "config", 10),
+	}
+
+	withKeyTimeout := opt
+	withKeyTimeout.KeyMaxTimeout = 2
+	changed["key timeout"] = cacheKey(withKeyTimeout, "", "image", "config", 10)
+
+	split := opt
+	split.Files = []SourceFile{{Path: "main.p", Content: "yprint(1)"}}
+	changed["file boundaries"] = cacheKey(split, "", "image", "config", 10)
+
+	for name, key := range changed {
+		if key == base {
+			t.Errorf("cache key doesn't change with the %s", name)
+		}
+	}
+}
+
+func TestConfigDigest(t *testing.T) {
+	dir := t.TempDir()
+	wd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	os.Mkdir("entry", 0755)
+	writeScript := func(name, content string) {
+		if err := os.WriteFile(filepath.Join("entry", name+".sh"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeScript("c", "/tmp/run")
+	writeScript("c.compile", "gcc $(find . -name '*.c') -o /tmp/run")
+
+	opt := ExecutionOptions{Entry: "c", Run: "c", Compile: "c.compile"}
+	ex := NewEngine(nil, 10, 10, 30, false, NewQueue(0, 0, 0), nil)
+	base := ex.configDigest(opt)
+
+	if ex.configDigest(opt) != base {
+		t.Error("digest is not stable")
+	}
+	if NewEngine(nil, 10, 20, 30, false, NewQueue(0, 0, 0), nil).configDigest(opt) == base {
+		t.Error("digest doesn't change with the compile timeout")
+	}
+	if NewEngine(nil, 10, 10, 60, false, NewQueue(0, 0, 0), nil).configDigest(opt) == base {
+		t.Error("digest doesn't change with the batch timeout")
+	}
+	if NewEngine(nil, 10, 10, 30, true, NewQueue(0, 0, 0), nil).configDigest(opt) == base {
+		t.Error("digest doesn't change with killOnOutputLimit")
+	}
+
+	writeScript("c.compile", "gcc -O2 $(find . -name '*.c') -o /tmp/run")
+	if ex.configDigest(opt) == base {
+		t.Error("digest doesn't change with the compile script")
+	}
+}
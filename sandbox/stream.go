@@ -0,0 +1,100 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package sandbox
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+/**
+ * Creates a writer that forwards the output of a phase to
+ * the given callback once the start marker has been seen.
+ *
+ * @param phase string Name of the phase
+ * @param stream string Name of the stream
+ * @param onOutput OutputFunc Callback for output chunks
+ * @return *streamWriter Stream writer
+ */
+func newStreamWriter(phase, stream string, onOutput OutputFunc) *streamWriter {
+	return &streamWriter{
+		phase:    phase,
+		stream:   stream,
+		marker:   []byte(stream + "-start\n"),
+		onOutput: onOutput,
+	}
+}
+
+/**
+ * Forwards a chunk of output. Output is held back until
+ * the start marker is verified, and never forwarded if it
+ * doesn't match. Incomplete UTF-8 sequences at the end of
+ * a chunk are carried over to the next one.
+ *
+ * @param p []byte Chunk of output
+ * @return int Number of bytes consumed
+ * @return error Always nil
+ */
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	if sw.broken {
+		return len(p), nil
+	}
+
+	data := append(sw.pending, p...)
+
+	if !sw.verified {
+		if len(data) < len(sw.marker) {
+			sw.pending = data
+			return len(p), nil
+		}
+		if !bytes.HasPrefix(data, sw.marker) {
+			sw.broken = true
+			sw.pending = nil
+			return len(p), nil
+		}
+		sw.verified = true
+		data = data[len(sw.marker):]
+	}
+
+	cut := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+
+	sw.pending = append([]byte(nil), data[cut:]...)
+	if cut > 0 {
+		sw.onOutput(sw.phase, sw.stream, data[:cut])
+	}
+
+	return len(p), nil
+}
+
+/**
+ * Forwards whatever is still held back. Called once the
+ * phase has exited.
+ */
+func (sw *streamWriter) flush() {
+	if sw.verified && len(sw.pending) > 0 {
+		sw.onOutput(sw.phase, sw.stream, sw.pending)
+		sw.pending = nil
+	}
+}
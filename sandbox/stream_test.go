@@ -0,0 +1,60 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package sandbox
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStreamWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		want   []string
+	}{
+		{"marker in one chunk", []string{"stdout-start\nhello"}, []string{"hello"}},
+		{"split marker", []string{"stdout-", "start\nhi", " there"}, []string{"hi", " there"}},
+		{"marker only", []string{"stdout-start\n"}, nil},
+		{"wrong marker", []string{"evil-start\nhello", "world"}, nil},
+		{"split rune", []string{"stdout-start\nh\xc3", "\xa9llo"}, []string{"h", "éllo"}},
+		{"split rune at the end", []string{"stdout-start\n\xe2\x82"}, []string{"\xe2\x82"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []string
+			sw := newStreamWriter("run", "stdout", func(phase, stream string, chunk []byte) {
+				if phase != "run" || stream != "stdout" {
+					t.Errorf("got output for %s/%s, want run/stdout", phase, stream)
+				}
+				got = append(got, string(chunk))
+			})
+
+			for _, chunk := range test.chunks {
+				if n, err := sw.Write([]byte(chunk)); err != nil || n != len(chunk) {
+					t.Fatalf("Write(%q) = %d, %v, want %d, nil", chunk, n, err, len(chunk))
+				}
+			}
+			sw.flush()
+
+			if strings.Join(got, "|") != strings.Join(test.want, "|") || len(got) != len(test.want) {
+				t.Errorf("forwarded %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+/**
+ * Compares the output of a test case with the expected
+ * output using the given mode.
+ *
+ * exact       Outputs must be identical
+ * trimmed     Leading and trailing whitespace is ignored
+ * whitespace  Outputs must have the same tokens
+ * float       Like whitespace, but numeric tokens may differ
+ *             by the given tolerance, relative to the
+ *             expected value if it's larger than 1
+ *
+ * @param mode string Comparison mode
+ * @param tolerance float64 Tolerance for float mode
+ * @param got string Actual output
+ * @param want string Expected output
+ * @return bool Whether the outputs match
+ */
+func CompareOutput(mode string, tolerance float64, got, want string) bool {
+	switch mode {
+	case "trimmed":
+		return strings.TrimSpace(got) == strings.TrimSpace(want)
+
+	case "whitespace", "float":
+		gotTokens, wantTokens := strings.Fields(got), strings.Fields(want)
+		if len(gotTokens) != len(wantTokens) {
+			return false
+		}
+
+		for i := range gotTokens {
+			if gotTokens[i] == wantTokens[i] {
+				continue
+			}
+			if mode != "float" {
+				return false
+			}
+
+			g, errG := strconv.ParseFloat(gotTokens[i], 64)
+			w, errW := strconv.ParseFloat(wantTokens[i], 64)
+			if errG != nil || errW != nil || math.Abs(g-w) > tolerance*math.Max(1, math.Abs(w)) {
+				return false
+			}
+		}
+		return true
+
+	default:
+		return got == want
+	}
+}
+
+/**
+ * Runs every test case against an already compiled
+ * submission in the given container. Cases share the
+ * batch budget, a case's timeout is shortened to what's
+ * left of it, and once it is used up the remaining cases
+ * are skipped.
+ *
+ * @param ctx context.Context Context of the execution
+ * @param b Box Sandbox to run in
+ * @param opt ExecutionOptions Execution options
+ * @param cArgs string Sanitized args
+ * @return []map[string]interface{} Result of each case
+ * @return int Number of cases that passed
+ * @return error Error object, set on unsafe output or
+ *   if the execution was cancelled
+ */
+func (ex *Engine) runTestCases(ctx context.Context, b Box, opt ExecutionOptions, cArgs string) ([]map[string]interface{}, int, error) {
+	results := make([]map[string]interface{}, 0, len(opt.TestCases))
+	maxTimeout := ex.maxTimeout(opt)
+
+	remaining := float64(ex.batchTimeout)
+	passed := 0
+
+	for _, testCase := range opt.TestCases {
+		timeout := testCase.Timeout
+		if timeout == 0 || timeout > maxTimeout {
+			timeout = maxTimeout
+		}
+
+		if remaining < 1 {
+			results = append(results, map[string]interface{}{"verdict": "skipped"})
+			continue
+		}
+		timeout = min(timeout, int(remaining))
+
+		run, err := ex.execPhase(ctx, b, phaseSpec{
+			name:      "run",
+			language:  opt.Entry,
+			command:   fmt.Sprintf("sh /entry.sh %s %s", Sanitize(opt.EntryPoint), cArgs),
+			timeout:   timeout,
+			stdin:     strings.NewReader(testCase.Stdin),
+			maxOutput: opt.Limits.Output,
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		remaining -= run.duration
+
+		verdict := "passed"
+		switch {
+		case run.timeout:
+			verdict = "timeout"
+		case run.exitCode != 0 || run.oomKilled:
+			verdict = "runtime_error"
+		case !CompareOutput(opt.CompareMode, opt.FloatTolerance, run.stdout, testCase.ExpectedStdout):
+			verdict = "wrong_answer"
+		default:
+			passed++
+		}
+
+		result := run.toMap()
+		result["verdict"] = verdict
+		results = append(results, result)
+	}
+
+	return results, passed, nil
+}
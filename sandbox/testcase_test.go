@@ -0,0 +1,180 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package sandbox
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+/**
+ * Box that runs phases on the host, with the entry and
+ * compile scripts replaced by true, and records the time
+ * each phase was given.
+ *
+ * @field timeouts []time.Duration Time given to each phase
+ * @field kills int Number of KillAll calls
+ * @field mu sync.Mutex Mutex for timeouts and kills
+ */
+type testBox struct {
+	timeouts []time.Duration
+	kills    int
+	mu       sync.Mutex
+}
+
+func (b *testBox) Command(ctx context.Context, interactive bool, script string) *exec.Cmd {
+	if deadline, ok := ctx.Deadline(); ok {
+		b.mu.Lock()
+		b.timeouts = append(b.timeouts, time.Until(deadline))
+		b.mu.Unlock()
+	}
+
+	script = strings.NewReplacer("sh /entry.sh", "true", "sh /compile.sh", "true").Replace(script)
+	return exec.CommandContext(ctx, "sh", "-c", script)
+}
+
+func (b *testBox) OOMKills() int { return 0 }
+func (b *testBox) Remove()       {}
+
+func (b *testBox) KillAll() {
+	b.mu.Lock()
+	b.kills++
+	b.mu.Unlock()
+}
+
+func TestExecPhaseTimeoutKillsAll(t *testing.T) {
+	ex := &Engine{}
+
+	b := &testBox{}
+	result, err := ex.execPhase(context.Background(), b, phaseSpec{name: "run", command: "exec sleep 5", timeout: 1})
+	if err != nil || !result.timeout {
+		t.Fatalf("execPhase = %+v, %v, want a timeout", result, err)
+	}
+	if b.kills != 1 {
+		t.Errorf("KillAll called %d times after a timeout, want 1", b.kills)
+	}
+
+	b = &testBox{}
+	if result, err := ex.execPhase(context.Background(), b, phaseSpec{name: "run", command: "true", timeout: 1}); err != nil || result.timeout {
+		t.Fatalf("execPhase = %+v, %v, want no timeout", result, err)
+	}
+	if b.kills != 0 {
+		t.Errorf("KillAll called %d times without a timeout, want 0", b.kills)
+	}
+}
+
+func TestRunTestCasesKeyMaxTimeout(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxTimeout    int
+		keyMaxTimeout int
+		caseTimeout   int
+		want          int
+	}{
+		{"key ceiling", 0, 2, 5, 2},
+		{"key ceiling without case timeout", 0, 2, 0, 2},
+		{"case below key ceiling", 0, 4, 3, 3},
+		{"language ceiling below key ceiling", 3, 8, 0, 3},
+		{"no key ceiling", 0, 0, 0, 10},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ex := NewEngine(nil, 10, 10, 30, false, NewQueue(0, 0, 0), nil)
+			b := &testBox{}
+			opt := ExecutionOptions{
+				EntryPoint:    "main.py",
+				MaxTimeout:    test.maxTimeout,
+				KeyMaxTimeout: test.keyMaxTimeout,
+				TestCases: []TestCase{
+					{Timeout: test.caseTimeout, ExpectedStdout: ""},
+				},
+			}
+
+			if _, _, err := ex.runTestCases(context.Background(), b, opt, ""); err != nil {
+				t.Fatalf("runTestCases: %v", err)
+			}
+			if len(b.timeouts) != 1 {
+				t.Fatalf("got %d phases, want 1", len(b.timeouts))
+			}
+
+			got := b.timeouts[0].Round(time.Second)
+			if want := time.Duration(test.want) * time.Second; got != want {
+				t.Errorf("case was given %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestRunTimeoutKeyMaxTimeout(t *testing.T) {
+	ex := NewEngine(nil, 10, 10, 30, false, NewQueue(0, 0, 0), nil)
+
+	tests := []struct {
+		opt  ExecutionOptions
+		want int
+	}{
+		{ExecutionOptions{}, 10},
+		{ExecutionOptions{Timeout: 20}, 10},
+		{ExecutionOptions{KeyMaxTimeout: 2}, 2},
+		{ExecutionOptions{Timeout: 5, KeyMaxTimeout: 2}, 2},
+		{ExecutionOptions{Timeout: 1, KeyMaxTimeout: 2}, 1},
+		{ExecutionOptions{MaxTimeout: 30, KeyMaxTimeout: 20}, 20},
+		{ExecutionOptions{MaxTimeout: 30, KeyMaxTimeout: 60}, 30},
+	}
+
+	for _, test := range tests {
+		if got := ex.runTimeout(test.opt); got != test.want {
+			t.Errorf("runTimeout(%+v) = %d, want %d", test.opt, got, test.want)
+		}
+	}
+}
+
+func TestCompareOutput(t *testing.T) {
+	tests := []struct {
+		mode      string
+		tolerance float64
+		got       string
+		want      string
+		match     bool
+	}{
+		{"exact", 0, "1 2\n", "1 2\n", true},
+		{"exact", 0, "1 2\n", "1 2", false},
+		{"", 0, "1 2", "1 2", true},
+		{"trimmed", 0, "  1 2\n\n", "1 2", true},
+		{"trimmed", 0, "1  2", "1 2", false},
+		{"whitespace", 0, "1\n2  3\n", "1 2 3", true},
+		{"whitespace", 0, "1 2", "1 2 3", false},
+		{"whitespace", 0, "1.0", "1", false},
+		{"float", 1e-6, "0.3333333", "0.33333333", true},
+		{"float", 1e-6, "0.33", "0.3333", false},
+		{"float", 1e-6, "1000000.5", "1000000", true},
+		{"float", 1e-6, "1000002", "1000000", false},
+		{"float", 1e-6, "abc 1.0", "abc 1", true},
+		{"float", 1e-6, "abd 1", "abc 1", false},
+		{"float", 1e-6, "1 2", "1", false},
+	}
+
+	for _, test := range tests {
+		if got := CompareOutput(test.mode, test.tolerance, test.got, test.want); got != test.match {
+			t.Errorf("CompareOutput(%q, %g, %q, %q) = %t, want %t", test.mode, test.tolerance, test.got, test.want, got, test.match)
+		}
+	}
+}
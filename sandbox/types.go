@@ -0,0 +1,394 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package sandbox
+
+import (
+	"container/list"
+	"context"
+	"io"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"whipcode/cache"
+)
+
+/**
+ * Interface for running executions. Implemented by the
+ * Engine for real sandboxes, and by in-memory fakes.
+ */
+type Executor interface {
+	/**
+	 * Runs an execution and returns its result.
+	 *
+	 * @param ctx context.Context Context of the execution,
+	 *   cancelling it kills the execution
+	 * @param opt ExecutionOptions Execution options
+	 * @return Result Result of the execution
+	 */
+	Run(ctx context.Context, opt ExecutionOptions) Result
+
+	/**
+	 * Returns the number of seconds clients are told to
+	 * wait before retrying a rejected execution.
+	 *
+	 * @return int Seconds to wait
+	 */
+	RetryAfter() int
+
+	/**
+	 * Stops accepting executions and waits for the running
+	 * ones to finish.
+	 *
+	 * @param ctx context.Context Deadline for the wait
+	 * @return error Context error if executions were still
+	 *   running when the deadline was reached
+	 */
+	Drain(ctx context.Context) error
+
+	/**
+	 * Releases everything the executor holds on to, killing
+	 * any executions that are still running.
+	 */
+	Close()
+}
+
+/**
+ * Struct for the result of an execution.
+ *
+ * @field Status int HTTP status code
+ * @field Body map[string]interface{} Response body
+ */
+type Result struct {
+	Status int
+	Body   map[string]interface{}
+}
+
+/**
+ * Interface for the sandbox backend that the Engine runs
+ * phases in.
+ */
+type Runtime interface {
+	/**
+	 * Prepares a sandbox with the files of the execution
+	 * in its working directory.
+	 *
+	 * @param opt ExecutionOptions Execution options
+	 * @param lifetime int Maximum lifetime in seconds
+	 * @return Box Prepared sandbox
+	 * @return error Error object
+	 */
+	Start(opt ExecutionOptions, lifetime int) (Box, error)
+
+	/**
+	 * Identifies the image or root filesystem executions
+	 * of the given language run in, so that cached results
+	 * are not reused once it changes.
+	 *
+	 * @param entry string Entry point, also names the image
+	 * @return string Image ID, empty if unknown
+	 */
+	ImageID(entry string) string
+
+	/**
+	 * Releases everything the runtime holds on to and kills
+	 * the sandboxes that are still in use.
+	 */
+	Close()
+}
+
+/**
+ * Interface for a prepared sandbox.
+ */
+type Box interface {
+	/**
+	 * Builds the command that runs a shell script inside
+	 * the sandbox. The command is killed when the context
+	 * is done.
+	 *
+	 * @param ctx context.Context Context of the phase
+	 * @param interactive bool Whether stdin is attached
+	 * @param script string Shell script to run
+	 * @return *exec.Cmd Command object
+	 */
+	Command(ctx context.Context, interactive bool, script string) *exec.Cmd
+
+	/**
+	 * Returns the number of processes killed by the OOM
+	 * killer so far, or 0 if it can't be told.
+	 *
+	 * @return int Number of OOM kills
+	 */
+	OOMKills() int
+
+	/**
+	 * Kills every process started in the sandbox, leaving
+	 * it usable for the next phase.
+	 */
+	KillAll()
+
+	/**
+	 * Destroys the sandbox and its files.
+	 */
+	Remove()
+}
+
+/**
+ * Struct for the executor that runs phases in sandboxes
+ * of a runtime.
+ *
+ * @field runtime Runtime Sandbox backend
+ * @field timeout int Timeout for the run phase
+ * @field compileTimeout int Timeout for the compile phase
+ * @field batchTimeout int Total timeout for all test cases
+ * @field killOnOutputLimit bool Kill the processes of a
+ *   phase once it exceeds the output limit
+ * @field queue *Queue Admission queue for executions
+ * @field store cache.Store Store for cached results, nil if
+ *   caching is disabled
+ * @field active atomic.Int64 Number of running executions
+ * @field draining atomic.Bool Whether new executions are
+ *   rejected
+ */
+type Engine struct {
+	runtime           Runtime
+	timeout           int
+	compileTimeout    int
+	batchTimeout      int
+	killOnOutputLimit bool
+	queue             *Queue
+	store             cache.Store
+	active            atomic.Int64
+	draining          atomic.Bool
+}
+
+/**
+ * Struct for the admission queue of an executor.
+ *
+ * @field maxRunning int Max concurrent executions, zero
+ *   for no limit
+ * @field maxQueued int Max executions waiting for a slot
+ * @field timeout time.Duration Max time to wait for a slot
+ * @field running int Executions holding a slot
+ * @field waiters *list.List Channels of waiting executions,
+ *   closed when they are handed a slot
+ * @field mu sync.Mutex Mutex for the counters and list
+ */
+type Queue struct {
+	maxRunning int
+	maxQueued  int
+	timeout    time.Duration
+	running    int
+	waiters    *list.List
+	mu         sync.Mutex
+}
+
+/**
+ * Struct for the resource limits of a container.
+ *
+ * @field Memory int64 Memory limit in bytes
+ * @field CPUs float64 Number of CPUs
+ * @field Pids int Max number of processes
+ * @field Tmpfs int64 Size of /tmp in bytes
+ * @field Output int64 Max bytes kept per output stream of
+ *   a phase, zero for no limit
+ */
+type Limits struct {
+	Memory int64
+	CPUs   float64
+	Pids   int
+	Tmpfs  int64
+	Output int64
+}
+
+/**
+ * Writer that passes at most a fixed number of bytes on
+ * and discards the rest, while still counting it.
+ *
+ * @field w io.Writer Underlying writer
+ * @field limit int64 Max bytes to pass on, including the
+ *   start marker, zero for no limit
+ * @field marker int64 Length of the start marker
+ * @field written int64 Bytes received so far
+ * @field exceeded bool Whether the limit was exceeded
+ * @field onExceed func() Called once the limit is exceeded
+ */
+type limitWriter struct {
+	w        io.Writer
+	limit    int64
+	marker   int64
+	written  int64
+	exceeded bool
+	onExceed func()
+}
+
+/**
+ * Struct for a phase to run inside a container.
+ *
+ * @field name string Name of the phase
+ * @field language string Entry of the language, for metrics
+ * @field command string Shell command to run
+ * @field timeout int Timeout in seconds
+ * @field stdin io.Reader Standard input, may be nil
+ * @field maxOutput int64 Max bytes kept per stream, zero
+ *   for no limit
+ * @field onOutput OutputFunc Callback for output chunks,
+ *   may be nil
+ */
+type phaseSpec struct {
+	name      string
+	language  string
+	command   string
+	timeout   int
+	stdin     io.Reader
+	maxOutput int64
+	onOutput  OutputFunc
+}
+
+/**
+ * Struct that forwards the output of a phase as it is
+ * produced.
+ *
+ * @field phase string Name of the phase
+ * @field stream string Name of the stream
+ * @field marker []byte Start marker of the stream
+ * @field pending []byte Output held back
+ * @field verified bool Whether the marker was seen
+ * @field broken bool Whether the marker didn't match
+ * @field onOutput OutputFunc Callback for output chunks
+ */
+type streamWriter struct {
+	phase    string
+	stream   string
+	marker   []byte
+	pending  []byte
+	verified bool
+	broken   bool
+	onOutput OutputFunc
+}
+
+/**
+ * Callback for output chunks. Called from the goroutines
+ * copying stdout and stderr, so it must be safe for
+ * concurrent use and must not retain the chunk.
+ */
+type OutputFunc func(phase, stream string, chunk []byte)
+
+/**
+ * Struct for the result of a single phase.
+ *
+ * @field stdout string Captured stdout
+ * @field stderr string Captured stderr
+ * @field exitCode int Exit code of the phase
+ * @field timeout bool Whether the phase timed out
+ * @field oomKilled bool Whether the OOM killer was triggered
+ * @field duration float64 Duration in seconds
+ * @field stdoutTruncated bool Whether stdout hit the limit
+ * @field stderrTruncated bool Whether stderr hit the limit
+ * @field stdoutBytes int64 Bytes written to stdout
+ * @field stderrBytes int64 Bytes written to stderr
+ */
+type phaseResult struct {
+	stdout          string
+	stderr          string
+	exitCode        int
+	timeout         bool
+	oomKilled       bool
+	duration        float64
+	stdoutTruncated bool
+	stderrTruncated bool
+	stdoutBytes     int64
+	stderrBytes     int64
+}
+
+/**
+ * Struct for defining a single file of a submission.
+ *
+ * @field Path string Path relative to the project root
+ * @field Content string File content
+ */
+type SourceFile struct {
+	Path    string
+	Content string
+}
+
+/**
+ * Struct for defining a test case run against the
+ * compiled submission.
+ *
+ * @field Stdin string Standard input
+ * @field ExpectedStdout string Expected standard output
+ * @field Timeout int Timeout for this case
+ */
+type TestCase struct {
+	Stdin          string
+	ExpectedStdout string
+	Timeout        int
+}
+
+/**
+ * Struct for defining execution options.
+ *
+ * @field Files []SourceFile Files to mount into the container
+ * @field EntryPoint string Path of the file passed to the entry script
+ * @field Entry string Entry point, also names the image
+ * @field Compile string Compile script, empty if there is
+ *   no separate compile phase
+ * @field Run string Run script
+ * @field Args string Compiler/interpreter arguments
+ * @field Stdin string Standard input, passed as is
+ * @field StdinReader io.Reader Live standard input for
+ *   interactive sessions, replaces Stdin if set
+ * @field Timeout int Execution timeout
+ * @field MaxTimeout int Ceiling for Timeout, the executor's
+ *   timeout is used if zero
+ * @field KeyMaxTimeout int Ceiling set by the key of the
+ *   request, applied on top of MaxTimeout to the run phase
+ *   and every test case, zero if the key has none
+ * @field Limits Limits Resource limits of the container
+ * @field Env map[string]string Environment variables
+ * @field TestCases []TestCase Test cases, replaces Stdin
+ * @field CompareMode string Output comparison mode
+ * @field FloatTolerance float64 Tolerance for float mode
+ * @field OnOutput OutputFunc Callback for streaming output,
+ *   may be nil
+ * @field EnableCache bool Enable cache
+ * @field RequestID string ID of the request, names the
+ *   sandbox and is added to log lines
+ */
+type ExecutionOptions struct {
+	Files          []SourceFile
+	EntryPoint     string
+	Entry          string
+	Compile        string
+	Run            string
+	Args           string
+	Stdin          string
+	StdinReader    io.Reader
+	Timeout        int
+	MaxTimeout     int
+	KeyMaxTimeout  int
+	Limits         Limits
+	Env            map[string]string
+	TestCases      []TestCase
+	CompareMode    string
+	FloatTolerance float64
+	OnOutput       OutputFunc
+	EnableCache    bool
+	RequestID      string
+}
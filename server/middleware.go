@@ -18,52 +18,193 @@
 
 import (
 	"context"
-	"fmt"
+	"crypto/rand"
+	"encoding/hex"
 	"net"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"whipcode/metrics"
 
 	"github.com/charmbracelet/log"
 )
 
 const (
-	LangMapContextKey     contextKey = "langMap"
-	MasterKeyContextKey   contextKey = "masterKey"
-	KeyStoreContextKey    contextKey = "keyStore"
-	EnableCacheContextKey contextKey = "enableCache"
-	ExecutorContextKey    contextKey = "executor"
+	LangMapContextKey        contextKey = "langMap"
+	KeyStoreContextKey       contextKey = "keyStore"
+	AuthGuardContextKey      contextKey = "authGuard"
+	ReplayCacheContextKey    contextKey = "replayCache"
+	EnableCacheContextKey    contextKey = "enableCache"
+	ExecutorContextKey       contextKey = "executor"
+	MaxTestCasesContextKey   contextKey = "maxTestCases"
+	JobStoreContextKey       contextKey = "jobStore"
+	IdleTimeoutContextKey    contextKey = "idleTimeout"
+	SessionOriginsContextKey contextKey = "sessionOrigins"
+	CeilingsContextKey       contextKey = "ceilings"
+	AuditLogContextKey       contextKey = "auditLog"
+	RequestInfoContextKey    contextKey = "requestInfo"
+	KeyContextKey            contextKey = "key"
 )
 
+/**
+ * Pattern client supplied request IDs must match. The ID
+ * names the sandbox of the request, so it is limited to
+ * characters that are safe in container names and paths.
+ */
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9-]{0,47}$`)
+
 /**
  * Middleware for the /run endpoint that caps the
  * request body size and passes various parameters
- * to the handler.
+ * to the handler. The parameters are loaded once per
+ * request, so a request sees either the old or the new
+ * ones when they are swapped on reload.
  *
  * @param f http.HandlerFunc Handler
- * @param params ScopedMiddleWareParams Parameters
+ * @param state *atomic.Pointer[ScopedMiddlewareParams]
+ *   Current parameters
  * @return http.HandlerFunc Handler
  */
-func ScopedMiddleware(f http.HandlerFunc, params ScopedMiddlewareParams) http.HandlerFunc {
+func ScopedMiddleware(f http.HandlerFunc, state *atomic.Pointer[ScopedMiddlewareParams]) http.HandlerFunc {
 	/**
 	 * @param w http.ResponseWriter Response writer
 	 * @param r *http.Request Request object
 	 */
 	return func(w http.ResponseWriter, r *http.Request) {
+		params := state.Load()
 		r.Body = http.MaxBytesReader(w, r.Body, int64(params.MaxBytesSize))
 
 		ctx := r.Context()
 		ctx = context.WithValue(ctx, LangMapContextKey, params.LangMap)
-		ctx = context.WithValue(ctx, MasterKeyContextKey, params.KeyAndSalt)
 		ctx = context.WithValue(ctx, KeyStoreContextKey, params.KeyStore)
+		ctx = context.WithValue(ctx, AuthGuardContextKey, params.AuthGuard)
+		ctx = context.WithValue(ctx, ReplayCacheContextKey, params.ReplayCache)
 		ctx = context.WithValue(ctx, EnableCacheContextKey, params.EnableCache)
 		ctx = context.WithValue(ctx, ExecutorContextKey, params.Executor)
+		ctx = context.WithValue(ctx, MaxTestCasesContextKey, params.MaxTestCases)
+		ctx = context.WithValue(ctx, JobStoreContextKey, params.JobStore)
+		ctx = context.WithValue(ctx, IdleTimeoutContextKey, params.IdleTimeout)
+		ctx = context.WithValue(ctx, SessionOriginsContextKey, params.SessionOrigins)
+		ctx = context.WithValue(ctx, CeilingsContextKey, params.Ceilings)
+		ctx = context.WithValue(ctx, AuditLogContextKey, params.AuditLog)
 
 		f(w, r.WithContext(ctx))
 	}
 }
 
+/**
+ * Returns the details of the request that are logged once
+ * it is done, for handlers to fill in.
+ *
+ * @param r *http.Request Request object
+ * @return *RequestInfo Request details
+ */
+func Info(r *http.Request) *RequestInfo {
+	if info, ok := r.Context().Value(RequestInfoContextKey).(*RequestInfo); ok {
+		return info
+	}
+	return &RequestInfo{}
+}
+
+/**
+ * Returns the address of the client. Requests from the
+ * reverse proxy are attributed to the last address in
+ * X-Forwarded-For, if it is set. That is the one the proxy
+ * appended, anything before it comes from the client and
+ * can't be trusted.
+ *
+ * @param r *http.Request Request object
+ * @param host string Address the request came from
+ * @param proxy string Reverse proxy address
+ * @return string Client address
+ */
+func clientIP(r *http.Request, host, proxy string) string {
+	if proxy == "" || host != proxy {
+		return host
+	}
+
+	values := r.Header.Values("X-Forwarded-For")
+	if len(values) == 0 {
+		return host
+	}
+
+	addresses := strings.Split(values[len(values)-1], ",")
+	if forwarded := strings.TrimSpace(addresses[len(addresses)-1]); forwarded != "" {
+		return forwarded
+	}
+	return host
+}
+
+/**
+ * Returns the ID of the request. A valid ID sent by the
+ * client in X-Request-ID is kept so that it can be traced
+ * across services, otherwise a random one is generated.
+ *
+ * @param r *http.Request Request object
+ * @return string Request ID
+ */
+func requestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-ID"); requestIDPattern.MatchString(id) {
+		return id
+	}
+
+	idBytes := make([]byte, 8)
+	rand.Read(idBytes)
+	return hex.EncodeToString(idBytes)
+}
+
+/**
+ * Writes the access log line of a finished request and
+ * counts it by route and status code.
+ *
+ * @param r *http.Request Request object
+ * @param w *statusRecorder Response writer of the request
+ * @param body *countingBody Request body
+ * @param startTime time.Time Time the request arrived
+ * @param blocked string Reason the request was turned
+ *   away, empty if it wasn't
+ */
+func logRequest(r *http.Request, w *statusRecorder, body *countingBody, startTime time.Time, blocked string) {
+	route, status := r.Pattern, w.status
+	if route == "" {
+		route = "unmatched"
+	}
+	if status == 0 {
+		status = http.StatusOK
+	}
+	metrics.Requests.WithLabelValues(route, strconv.Itoa(status)).Inc()
+
+	info := Info(r)
+	fields := []interface{}{
+		"RequestID", info.ID,
+		"IP", info.ClientIP,
+		"Status", status,
+		"Duration", time.Since(startTime).Seconds(),
+		"BytesIn", body.bytes,
+		"BytesOut", w.bytes,
+	}
+	if info.KeyID != "" {
+		fields = append(fields, "Key", info.KeyID)
+	}
+	if info.Language != "" {
+		fields = append(fields, "Language", info.Language, "Timeout", info.Timeout, "Cached", info.Cached)
+	}
+	if blocked != "" {
+		fields = append(fields, "Blocked", blocked)
+	}
+
+	log.Info(r.Method+" "+r.URL.Path, fields...)
+}
+
 /**
  * Global middleware for all requests that performs
- * rate limiting and host checks.
+ * rate limiting and host checks, turns requests away
+ * while the server is shutting down, and writes an access
+ * log line for every request.
  *
  * @param handler http.Handler Handler
  * @param params MiddleWareParams Parameters
@@ -74,24 +215,46 @@ func Middleware(handler http.Handler, params MiddlewareParams) http.Handler {
 	 * @param w http.ResponseWriter - Response writer
 	 * @param r *http.Request - Request object
 	 */
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		startTime := time.Now()
 		host, _, _ := net.SplitHostPort(r.RemoteAddr)
-		details := fmt.Sprintf("%s %s %s", host, r.Method, r.URL)
+
+		info := &RequestInfo{
+			ID:       requestID(r),
+			ClientIP: clientIP(r, host, params.Proxy),
+		}
+		r = r.WithContext(context.WithValue(r.Context(), RequestInfoContextKey, info))
+		rw.Header().Set("X-Request-ID", info.ID)
+
+		w := &statusRecorder{ResponseWriter: rw}
+		body := &countingBody{ReadCloser: r.Body}
+		r.Body = body
+
+		blocked := ""
+		defer func() {
+			logRequest(r, w, body, startTime, blocked)
+		}()
 
 		if params.Proxy != "" && host != params.Proxy {
-			log.Warn(details, "Blocked", "host not allowed")
+			blocked = "host not allowed"
 			w.WriteHeader(http.StatusForbidden)
 			return
 		}
 
+		if params.Draining.Load() {
+			blocked = "shutting down"
+			w.Header().Set("Connection", "close")
+			Send(w, http.StatusServiceUnavailable, []byte(`{"detail": "server is shutting down"}`))
+			return
+		}
+
 		if params.Standalone && !params.RateLimiter.CheckClient(host, params.RlBurst, params.RlRefill) {
-			log.Info(details, "Blocked", "rate limit exceeded")
+			blocked = "rate limit exceeded"
+			metrics.RateLimited.Inc()
 			Send(w, http.StatusTooManyRequests, []byte(`{"detail": "you are sending too many requests"}`))
 			return
 		}
 
-		log.Info(details)
-
 		handler.ServeHTTP(w, r)
 	})
 }
@@ -0,0 +1,80 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMiddlewareRequestID(t *testing.T) {
+	var info *RequestInfo
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info = Info(r)
+		Send(w, http.StatusBadRequest, []byte(`{"detail": "invalid request format"}`))
+	}), MiddlewareParams{Draining: new(atomic.Bool)})
+
+	for sent, keep := range map[string]bool{"trace-1234": true, "": false, "bad id\n": false} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if sent != "" {
+			r.Header.Set("X-Request-ID", sent)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		id := w.Header().Get("X-Request-ID")
+		if id == "" || id != info.ID || (id == sent) != keep {
+			t.Errorf("X-Request-ID %q: got %q, logged %q", sent, id, info.ID)
+		}
+
+		var body map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body["request_id"] != id {
+			t.Errorf("X-Request-ID %q: body %s doesn't carry %q", sent, w.Body.String(), id)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		host      string
+		proxy     string
+		forwarded []string
+		want      string
+	}{
+		{"192.0.2.1", "", []string{"198.51.100.1"}, "192.0.2.1"},
+		{"192.0.2.1", "192.0.2.2", []string{"198.51.100.1"}, "192.0.2.1"},
+		{"192.0.2.2", "192.0.2.2", nil, "192.0.2.2"},
+		{"192.0.2.2", "192.0.2.2", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"192.0.2.2", "192.0.2.2", []string{"203.0.113.9, 198.51.100.1"}, "198.51.100.1"},
+		{"192.0.2.2", "192.0.2.2", []string{"203.0.113.9", "198.51.100.1"}, "198.51.100.1"},
+		{"192.0.2.2", "192.0.2.2", []string{"203.0.113.9, "}, "192.0.2.2"},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		for _, value := range test.forwarded {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+
+		if got := clientIP(r, test.host, test.proxy); got != test.want {
+			t.Errorf("clientIP(%q from %s, proxy %q) = %s, want %s", test.forwarded, test.host, test.proxy, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package server
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+)
+
+/**
+ * Records the status code and writes the header.
+ *
+ * @param status int Status code
+ */
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+/**
+ * Writes to the response, recording an implicit 200 if
+ * no header was written yet.
+ *
+ * @param b []byte Data to write
+ * @return int Number of bytes written
+ * @return error Error object
+ */
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+/**
+ * Flushes the wrapped writer, for streamed responses.
+ */
+func (rec *statusRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+/**
+ * Hijacks the connection of the wrapped writer, for
+ * WebSocket upgrades, which are recorded as 101.
+ *
+ * @return net.Conn Hijacked connection
+ * @return *bufio.ReadWriter Buffered reader and writer
+ * @return error Error object
+ */
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking not supported")
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err == nil && rec.status == 0 {
+		rec.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+/**
+ * Returns the wrapped writer, for http.ResponseController.
+ *
+ * @return http.ResponseWriter Wrapped writer
+ */
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+/**
+ * Reads from the wrapped body, counting the bytes read.
+ *
+ * @param p []byte Buffer to read into
+ * @return int Number of bytes read
+ * @return error Error object
+ */
+func (body *countingBody) Read(p []byte) (int, error) {
+	n, err := body.ReadCloser.Read(p)
+	body.bytes += int64(n)
+	return n, err
+}
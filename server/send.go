@@ -17,13 +17,16 @@
 package server
 
 import (
+	"encoding/json"
 	"net/http"
 
 	"github.com/charmbracelet/log"
 )
 
 /**
- * Send sends a response to the client.
+ * Send sends a response to the client. The request ID is
+ * added to JSON error bodies that don't have it yet, so
+ * that clients can report it.
  *
  * @param w http.ResponseWriter Response writer
  * @param status int Status code to return
@@ -37,6 +40,10 @@ func Send(w http.ResponseWriter, status int, message []byte, contentType ...stri
 		cType = contentType[0]
 	}
 
+	if id := w.Header().Get("X-Request-ID"); id != "" && status >= http.StatusBadRequest && cType == "application/json" {
+		message = withRequestID(message, id)
+	}
+
 	w.Header().Set("Content-Type", cType)
 	w.WriteHeader(status)
 
@@ -45,3 +52,29 @@ func Send(w http.ResponseWriter, status int, message []byte, contentType ...stri
 		log.Error("Failed to write response", "Error", err)
 	}
 }
+
+/**
+ * Adds the request ID to a JSON object, unless it already
+ * has one. Anything that isn't a JSON object is returned
+ * as is.
+ *
+ * @param message []byte JSON message
+ * @param id string Request ID
+ * @return []byte Message with the request ID
+ */
+func withRequestID(message []byte, id string) []byte {
+	var body map[string]interface{}
+	if err := json.Unmarshal(message, &body); err != nil || body == nil {
+		return message
+	}
+	if _, exists := body["request_id"]; exists {
+		return message
+	}
+
+	body["request_id"] = id
+	withID, err := json.Marshal(body)
+	if err != nil {
+		return message
+	}
+	return withID
+}
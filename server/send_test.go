@@ -0,0 +1,48 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSendRequestID(t *testing.T) {
+	tests := []struct {
+		status      int
+		message     string
+		contentType []string
+		want        string
+	}{
+		{http.StatusBadRequest, `{"detail": "invalid request format"}`, nil, `{"detail":"invalid request format","request_id":"abc"}`},
+		{http.StatusUnauthorized, `{"detail": "unauthorized", "request_id": "other"}`, nil, `{"detail": "unauthorized", "request_id": "other"}`},
+		{http.StatusOK, `{"stdout": ""}`, nil, `{"stdout": ""}`},
+		{http.StatusNotFound, `not found`, []string{"text/plain"}, `not found`},
+		{http.StatusBadRequest, `[1]`, nil, `[1]`},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		w.Header().Set("X-Request-ID", "abc")
+		Send(w, test.status, []byte(test.message), test.contentType...)
+
+		if w.Code != test.status || w.Body.String() != test.want {
+			t.Errorf("Send(%d, %s) wrote %d %s, want %s", test.status, test.message, w.Code, w.Body.String(), test.want)
+		}
+	}
+}
@@ -17,25 +17,40 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"sync/atomic"
 	"time"
 
+	"whipcode/metrics"
+	"whipcode/sandbox"
+
 	"github.com/charmbracelet/log"
 )
 
+/**
+ * Seconds handlers are given to reply after the remaining
+ * executions were killed at the drain deadline.
+ */
+const shutdownGrace = 5
+
 /**
  * Starts the server with the given port, handler, and
- * TLS settings.
+ * TLS settings. The server is run in the background until
+ * it is shut down.
  *
  * @param port int Port to use
  * @param addr string Address to use
  * @param handler http.Handler Handler to use
  * @param enableTLS bool Whether to enable TLS
  * @param tlsDir string Directory for the TLS files
- * @param timeout int Configured execution timeout
+ * @param timeout int Longest time in seconds an execution
+ *   may take, including the wait for a queue slot
+ * @return *http.Server Server object
  */
-func StartServer(port int, addr string, handler http.Handler, enableTLS bool, tlsDir string, timeout int) {
+func StartServer(port int, addr string, handler http.Handler, enableTLS bool, tlsDir string, timeout int) *http.Server {
 	listen := fmt.Sprintf("%s:%d", addr, port)
 	log.Info("Starting whipcode", "Listen", listen, "TLS", enableTLS)
 
@@ -55,7 +70,75 @@ func StartServer(port int, addr string, handler http.Handler, enableTLS bool, tl
 		}
 	}
 
-	if err := Serve(); err != nil {
-		log.Fatal("Server failed", "Error", err)
+	go func() {
+		if err := Serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal("Server failed", "Error", err)
+		}
+	}()
+
+	return srv
+}
+
+/**
+ * Starts a separate server for the /metrics endpoint, so
+ * that it can be kept off the public address. It is not
+ * shut down gracefully, scrapes are cheap to retry.
+ *
+ * @param addr string Address to listen on, host:port
+ */
+func StartMetricsServer(addr string) {
+	log.Info("Starting metrics server", "Listen", addr)
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metrics.Handler())
+
+	srv := &http.Server{
+		Addr:         addr,
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil {
+			log.Fatal("Metrics server failed", "Error", err)
+		}
+	}()
+}
+
+/**
+ * Shuts the server down gracefully. New requests are
+ * turned away with 503 while the running executions are
+ * given until the drain deadline to finish, after which
+ * the remaining ones are killed. The server is closed
+ * once their handlers have replied, and the temp
+ * directory is cleaned up last.
+ *
+ * @param srv *http.Server Server to shut down
+ * @param draining *atomic.Bool Flag checked by the global
+ *   middleware
+ * @param executor sandbox.Executor Executor to drain
+ * @param drainTimeout int Seconds to wait for executions
+ */
+func Shutdown(srv *http.Server, draining *atomic.Bool, executor sandbox.Executor, drainTimeout int) {
+	log.Info("Shutting down, waiting for running executions", "Deadline", drainTimeout)
+	draining.Store(true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(drainTimeout)*time.Second)
+	defer cancel()
+
+	if err := executor.Drain(ctx); err != nil {
+		log.Warn("Drain deadline reached, killing remaining executions")
+	}
+	executor.Close()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownGrace*time.Second)
+	defer shutdownCancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Warn("Could not close all connections", "Error", err)
 	}
+
+	sandbox.Cleanup()
+	log.Info("Shutdown complete")
 }
@@ -17,13 +17,41 @@
 package server
 
 import (
+	"io"
+	"net/http"
+	"sync/atomic"
+
+	"whipcode/audit"
 	"whipcode/control"
-	"whipcode/podman"
+	"whipcode/jobs"
+	"whipcode/sandbox"
 )
 
 type contextKey string
 
-type LangMap map[string]map[string]string
+/**
+ * Struct for a language in the language map.
+ *
+ * @field Entry string Entry script, also names the image
+ * @field Ext string File extension
+ * @field Compile string Compile script, may be empty
+ * @field Run string Run script, defaults to Entry
+ * @field MaxTimeout int Ceiling for the run timeout, the
+ *   global timeout is used if zero
+ * @field Limits sandbox.Limits Resource limits
+ * @field PoolSize int Number of warm containers to keep
+ */
+type Language struct {
+	Entry      string
+	Ext        string
+	Compile    string
+	Run        string
+	MaxTimeout int
+	Limits     sandbox.Limits
+	PoolSize   int
+}
+
+type LangMap map[string]Language
 
 /**
  * Struct that's used to pass options to the /run
@@ -31,18 +59,36 @@
  *
  * @field LangMap LangMap Language map
  * @field EnableCache bool Enable cache
- * @field KeyAndSalt []string Key and salt
  * @field MaxBytesSize int Maximum bytes size
- * @field KeyStore *control.KeyStore Cached Key store
- * @field Executor podman.Executor Podman executor
+ * @field MaxTestCases int Maximum test cases per request
+ * @field Ceilings sandbox.Limits Ceilings for requested
+ *   resource limits
+ * @field KeyStore *control.KeyStore Key store
+ * @field AuthGuard *control.AuthGuard Guard against brute
+ *   forcing keys, nil if disabled
+ * @field ReplayCache *control.ReplayCache Nonces of signed
+ *   requests, nil if signed requests are disabled
+ * @field Executor sandbox.Executor Executor for running code
+ * @field JobStore *jobs.Store Store for background jobs
+ * @field IdleTimeout int Idle timeout for sessions
+ * @field SessionOrigins []string Origins allowed to open
+ *   sessions besides the server's own
+ * @field AuditLog *audit.Logger Audit log, nil if disabled
  */
 type ScopedMiddlewareParams struct {
-	LangMap      LangMap
-	EnableCache  bool
-	KeyAndSalt   []string
-	MaxBytesSize int
-	KeyStore     *control.KeyStore
-	Executor     podman.Executor
+	LangMap        LangMap
+	EnableCache    bool
+	MaxBytesSize   int
+	MaxTestCases   int
+	Ceilings       sandbox.Limits
+	KeyStore       *control.KeyStore
+	AuthGuard      *control.AuthGuard
+	ReplayCache    *control.ReplayCache
+	Executor       sandbox.Executor
+	JobStore       *jobs.Store
+	IdleTimeout    int
+	SessionOrigins []string
+	AuditLog       *audit.Logger
 }
 
 /**
@@ -54,6 +100,7 @@ type ScopedMiddlewareParams struct {
  * @field RlBurst int Rate limiter burst
  * @field RlRefill int Rate limiter refill
  * @field Proxy string Reverse proxy address
+ * @field Draining *atomic.Bool Set while shutting down
  */
 type MiddlewareParams struct {
 	RateLimiter *control.RateLimiter
@@ -61,4 +108,54 @@ type MiddlewareParams struct {
 	RlBurst     int
 	RlRefill    int
 	Proxy       string
+	Draining    *atomic.Bool
+}
+
+/**
+ * Struct that wraps a response writer to record the status
+ * code of the response.
+ *
+ * @field ResponseWriter http.ResponseWriter Wrapped writer
+ * @field status int Status code, zero until written
+ * @field bytes int64 Number of body bytes written
+ */
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+/**
+ * Struct that wraps a request body to count the bytes
+ * read from it.
+ *
+ * @field ReadCloser io.ReadCloser Wrapped body
+ * @field bytes int64 Number of bytes read
+ */
+type countingBody struct {
+	io.ReadCloser
+	bytes int64
+}
+
+/**
+ * Struct for the details of a request that end up in its
+ * access log line. Created by the global middleware and
+ * filled in by the handlers as they learn more about the
+ * request.
+ *
+ * @field ID string Request ID
+ * @field ClientIP string Address of the client
+ * @field KeyID string ID of the key the request was
+ *   authorized with, empty if it wasn't
+ * @field Language string Entry of the requested language
+ * @field Timeout bool Whether the execution timed out
+ * @field Cached bool Whether the result came from the cache
+ */
+type RequestInfo struct {
+	ID       string
+	ClientIP string
+	KeyID    string
+	Language string
+	Timeout  bool
+	Cached   bool
 }
@@ -23,9 +23,10 @@
 	"os"
 	"strings"
 
+	"whipcode/control"
+
 	"github.com/charmbracelet/huh"
 	"github.com/fatih/color"
-	"golang.org/x/crypto/argon2"
 )
 
 /**
@@ -95,8 +96,6 @@ func GenKey() {
 		fmt.Println("It won't be shown again, so make sure to save it somewhere safe.")
 	}
 
-	hash := argon2.IDKey([]byte(key), []byte(salt), 1, 4096, 1, 32)
-
 	file, err := os.Create(".masterkey")
 	if err != nil {
 		color.Red("Could not create .masterkey: %v", err)
@@ -104,6 +103,6 @@ func GenKey() {
 	}
 	defer file.Close()
 
-	file.WriteString(hex.EncodeToString(hash) + "\n" + salt)
+	file.WriteString(control.HashSecret(key, salt) + "\n" + salt)
 	color.Green("Hash and salt saved to .masterkey")
 }
@@ -0,0 +1,461 @@
+//
+//  Copyright 2024 whipcode.app (AnnikaV9)
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//          http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on
+//  an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific
+//  language governing permissions and limitations under the License.
+//
+
+package utils
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"whipcode/control"
+
+	"github.com/fatih/color"
+)
+
+/**
+ * Runs a key management command on the key registry.
+ * Exits with a non-zero code if the command fails.
+ *
+ * @param args []string Command and its arguments
+ * @param registryFile string Default key registry file
+ */
+func Keys(args []string, registryFile string) {
+	if len(args) == 0 {
+		keysUsage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch args[0] {
+	case "add":
+		err = keysAdd(args[1:], registryFile)
+	case "list":
+		err = keysList(args[1:], registryFile)
+	case "revoke":
+		err = keysRevoke(args[1:], registryFile)
+	case "rotate":
+		err = keysRotate(args[1:], registryFile)
+	case "-h", "--help", "help":
+		keysUsage()
+		return
+	default:
+		keysUsage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		color.New(color.FgRed).Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+/**
+ * Prints the usage of the key management commands.
+ */
+func keysUsage() {
+	fmt.Printf("usage: %s keys <command> [options]\n", os.Args[0])
+	fmt.Println(`
+commands:
+    add ID                    add a key and print it
+    list                      list the keys
+    revoke ID                 disable a key
+    rotate ID                 replace the secret of a key and print it
+
+options:
+    --keys           FILE     key registry file
+    --label          TEXT     label of the key (add)
+    --languages      LIST     comma separated entries the key may
+                              run, empty for all (add)
+    --max-timeout    SECONDS  timeout ceiling of the key (add)
+    --disabled                add the key disabled (add)
+    --signing                 give the key a signing secret for
+                              signed requests and print it (add,
+                              rotate)
+    --secret-stdin            read the secret from stdin instead
+                              of generating one (add, rotate)
+    --json                    print as JSON (list)`)
+}
+
+/**
+ * Parses the flags of a command. The ID of the key may be
+ * given before or after the flags. Exits after printing
+ * the usage if the flags are invalid or help was asked for.
+ *
+ * @param fs *flag.FlagSet Flags of the command
+ * @param args []string Arguments of the command
+ * @param needID bool Whether the command takes a key ID
+ * @return string Key ID
+ * @return error Error object
+ */
+func parseKeyArgs(fs *flag.FlagSet, args []string, needID bool) (string, error) {
+	fs.Usage = keysUsage
+	parse := func(args []string) {
+		if err := fs.Parse(args); err == flag.ErrHelp {
+			os.Exit(0)
+		} else if err != nil {
+			os.Exit(2)
+		}
+	}
+
+	parse(args)
+	id := ""
+	if needID && fs.NArg() > 0 {
+		id = fs.Arg(0)
+		parse(fs.Args()[1:])
+	}
+
+	switch {
+	case needID && id == "":
+		return "", fmt.Errorf("missing key id")
+	case fs.NArg() > 0:
+		return "", fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	return id, nil
+}
+
+/**
+ * Takes an exclusive lock on the key registry, so that
+ * concurrent commands don't overwrite each other's
+ * changes. The lock is held on a separate lock file, as
+ * the registry itself is replaced on every write.
+ *
+ * @param path string Key registry file
+ * @return func() Releases the lock
+ * @return error Error object
+ */
+func lockRegistry(path string) (func(), error) {
+	lockFile, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("could not lock %s: %w", path, err)
+	}
+
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX); err != nil {
+		lockFile.Close()
+		return nil, fmt.Errorf("could not lock %s: %w", path, err)
+	}
+
+	return func() {
+		syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+		lockFile.Close()
+	}, nil
+}
+
+/**
+ * Reads the key registry, starting with an empty one if
+ * the file doesn't exist yet.
+ *
+ * @param path string Key registry file
+ * @return *control.Registry Key registry
+ * @return error Error object
+ */
+func readRegistry(path string) (*control.Registry, error) {
+	registry, err := control.ReadRegistry(path)
+	if os.IsNotExist(err) {
+		return &control.Registry{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", path, err)
+	}
+	return registry, nil
+}
+
+/**
+ * Returns the secret for a new or rotated key, read from
+ * the first line of stdin or generated.
+ *
+ * @param fromStdin bool Read the secret from stdin
+ * @return string Secret
+ * @return error Error object
+ */
+func newSecret(fromStdin bool) (string, error) {
+	if !fromStdin {
+		return RandomString(32), nil
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("could not read secret from stdin: %w", err)
+	}
+
+	secret := strings.TrimSpace(line)
+	if len(secret) < 16 {
+		return "", fmt.Errorf("secret must be at least 16 characters")
+	}
+	return secret, nil
+}
+
+/**
+ * Prints a new key and signing secret to stdout, one per
+ * line, and a reminder that they won't be shown again to
+ * stderr.
+ *
+ * @param id string Key ID
+ * @param secret string Secret of the key, empty if it
+ *   wasn't generated
+ * @param signingSecret string Signing secret, empty if
+ *   there is none
+ */
+func printKey(id, secret, signingSecret string) {
+	if secret == "" && signingSecret == "" {
+		return
+	}
+
+	if secret != "" {
+		fmt.Println(id + "." + secret)
+	}
+	if signingSecret != "" {
+		fmt.Println(signingSecret)
+	}
+	color.New(color.FgYellow).Fprintln(os.Stderr, "This is the only time the key is shown, make sure to save it somewhere safe.")
+}
+
+/**
+ * Adds a key to the registry and prints it.
+ *
+ * @param args []string Arguments of the command
+ * @param registryFile string Default key registry file
+ * @return error Error object
+ */
+func keysAdd(args []string, registryFile string) error {
+	fs := flag.NewFlagSet("keys add", flag.ContinueOnError)
+	fs.StringVar(&registryFile, "keys", registryFile, "")
+	label := fs.String("label", "", "")
+	languages := fs.String("languages", "", "")
+	maxTimeout := fs.Int("max-timeout", 0, "")
+	disabled := fs.Bool("disabled", false, "")
+	signing := fs.Bool("signing", false, "")
+	secretStdin := fs.Bool("secret-stdin", false, "")
+
+	id, err := parseKeyArgs(fs, args, true)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockRegistry(registryFile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	registry, err := readRegistry(registryFile)
+	if err != nil {
+		return err
+	}
+	if registry.Find(id) != nil {
+		return fmt.Errorf("key %s already exists", id)
+	}
+
+	secret, err := newSecret(*secretStdin)
+	if err != nil {
+		return err
+	}
+
+	var languageList []string
+	for _, language := range strings.Split(*languages, ",") {
+		if language = strings.TrimSpace(language); language != "" {
+			languageList = append(languageList, language)
+		}
+	}
+
+	signingSecret := ""
+	if *signing {
+		signingSecret = RandomString(32)
+	}
+
+	enabled := !*disabled
+	salt := RandomString(16)
+	registry.Keys = append(registry.Keys, control.RegistryEntry{
+		ID:            id,
+		Hash:          control.HashSecret(secret, salt),
+		Salt:          salt,
+		Label:         *label,
+		Languages:     languageList,
+		MaxTimeout:    *maxTimeout,
+		Enabled:       &enabled,
+		SigningSecret: signingSecret,
+	})
+
+	if err := registry.Write(registryFile); err != nil {
+		return fmt.Errorf("could not write %s: %w", registryFile, err)
+	}
+
+	color.New(color.FgGreen).Fprintf(os.Stderr, "Added key %s to %s\n", id, registryFile)
+	if *secretStdin {
+		secret = ""
+	}
+	printKey(id, secret, signingSecret)
+	return nil
+}
+
+/**
+ * Lists the keys of the registry, without their hashes.
+ *
+ * @param args []string Arguments of the command
+ * @param registryFile string Default key registry file
+ * @return error Error object
+ */
+func keysList(args []string, registryFile string) error {
+	fs := flag.NewFlagSet("keys list", flag.ContinueOnError)
+	fs.StringVar(&registryFile, "keys", registryFile, "")
+	asJSON := fs.Bool("json", false, "")
+
+	if _, err := parseKeyArgs(fs, args, false); err != nil {
+		return err
+	}
+
+	registry, err := readRegistry(registryFile)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		keys := make([]map[string]interface{}, 0, len(registry.Keys))
+		for _, entry := range registry.Keys {
+			keys = append(keys, map[string]interface{}{
+				"id":          entry.ID,
+				"label":       entry.Label,
+				"languages":   append([]string{}, entry.Languages...),
+				"max_timeout": entry.MaxTimeout,
+				"enabled":     entry.Enabled == nil || *entry.Enabled,
+				"signing":     entry.SigningSecret != "",
+			})
+		}
+		keysBytes, _ := json.MarshalIndent(keys, "", "  ")
+		fmt.Println(string(keysBytes))
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tLABEL\tLANGUAGES\tMAX TIMEOUT\tENABLED\tSIGNING")
+	for _, entry := range registry.Keys {
+		languages, maxTimeout := "all", "-"
+		if len(entry.Languages) > 0 {
+			languages = strings.Join(entry.Languages, ",")
+		}
+		if entry.MaxTimeout > 0 {
+			maxTimeout = strconv.Itoa(entry.MaxTimeout)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%t\t%t\n", entry.ID, entry.Label, languages, maxTimeout, entry.Enabled == nil || *entry.Enabled, entry.SigningSecret != "")
+	}
+	return tw.Flush()
+}
+
+/**
+ * Disables a key of the registry. The key is kept so that
+ * its ID still identifies it in old logs.
+ *
+ * @param args []string Arguments of the command
+ * @param registryFile string Default key registry file
+ * @return error Error object
+ */
+func keysRevoke(args []string, registryFile string) error {
+	fs := flag.NewFlagSet("keys revoke", flag.ContinueOnError)
+	fs.StringVar(&registryFile, "keys", registryFile, "")
+
+	id, err := parseKeyArgs(fs, args, true)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockRegistry(registryFile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	registry, err := readRegistry(registryFile)
+	if err != nil {
+		return err
+	}
+	entry := registry.Find(id)
+	if entry == nil {
+		return fmt.Errorf("key %s not found", id)
+	}
+
+	enabled := false
+	entry.Enabled = &enabled
+	if err := registry.Write(registryFile); err != nil {
+		return fmt.Errorf("could not write %s: %w", registryFile, err)
+	}
+
+	color.New(color.FgGreen).Fprintf(os.Stderr, "Revoked key %s\n", id)
+	return nil
+}
+
+/**
+ * Replaces the secret of a key of the registry and prints
+ * the new key. Keys with a signing secret, or all keys with
+ * --signing, get a new signing secret as well. The old
+ * secrets stop working once the server is reloaded.
+ *
+ * @param args []string Arguments of the command
+ * @param registryFile string Default key registry file
+ * @return error Error object
+ */
+func keysRotate(args []string, registryFile string) error {
+	fs := flag.NewFlagSet("keys rotate", flag.ContinueOnError)
+	fs.StringVar(&registryFile, "keys", registryFile, "")
+	signing := fs.Bool("signing", false, "")
+	secretStdin := fs.Bool("secret-stdin", false, "")
+
+	id, err := parseKeyArgs(fs, args, true)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockRegistry(registryFile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	registry, err := readRegistry(registryFile)
+	if err != nil {
+		return err
+	}
+	entry := registry.Find(id)
+	if entry == nil {
+		return fmt.Errorf("key %s not found", id)
+	}
+
+	secret, err := newSecret(*secretStdin)
+	if err != nil {
+		return err
+	}
+
+	entry.Salt = RandomString(16)
+	entry.Hash = control.HashSecret(secret, entry.Salt)
+	if *signing || entry.SigningSecret != "" {
+		entry.SigningSecret = RandomString(32)
+	}
+	if err := registry.Write(registryFile); err != nil {
+		return fmt.Errorf("could not write %s: %w", registryFile, err)
+	}
+
+	color.New(color.FgGreen).Fprintf(os.Stderr, "Rotated key %s\n", id)
+	if *secretStdin {
+		secret = ""
+	}
+	printKey(id, secret, entry.SigningSecret)
+	return nil
+}